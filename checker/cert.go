@@ -0,0 +1,37 @@
+package checker
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// certExpiry returns the earliest expiry of the certificate chain
+func certExpiry(chain []*x509.Certificate) time.Time {
+	var expiry time.Time
+	for _, cert := range chain {
+		if expiry.IsZero() || cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+	return expiry
+}
+
+// checkCertExpiry fails the report when the certificate chain expires within
+// the critical window and sets its warning within the warning window
+func (c *Checker) checkCertExpiry(r *report, now time.Time) error {
+	if r.certExpiry.IsZero() {
+		return nil
+	}
+
+	left := r.certExpiry.Sub(now)
+	switch {
+	case left <= 0:
+		return &CheckError{Class: ErrorTLS, Err: fmt.Errorf("certificate expired at %s", r.certExpiry.Format(time.RFC3339))}
+	case left < c.CertCritical:
+		return &CheckError{Class: ErrorTLS, Err: fmt.Errorf("certificate expires at %s", r.certExpiry.Format(time.RFC3339))}
+	case left < c.CertWarning:
+		r.warning = fmt.Sprintf("certificate expires at %s", r.certExpiry.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -1,66 +1,221 @@
 package checker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// ClusterStatus is the health status of the cluster
+type ClusterStatus string
+
+// Cluster statuses
+const (
+	ClusterHealthy   ClusterStatus = "healthy"
+	ClusterUnhealthy ClusterStatus = "unhealthy"
+	ClusterUnknown   ClusterStatus = "unknown"
+)
+
 type Cluster struct {
-	Name    string `json:"name"`    // The cluster name (ID)
-	Healthy bool   `json:"healthy"` // Health status
-	Total   int    `json:"total"`   // Total monitored services
-	Failed  int    `json:"failed"`  // Failed services
+	Name     string        `json:"name"`             // The cluster name (ID)
+	Healthy  bool          `json:"healthy"`          // Health status
+	Status   ClusterStatus `json:"status"`           // Health status, unknown if it can't be determined
+	Reason   string        `json:"reason,omitempty"` // Why the cluster is not healthy
+	Total    int           `json:"total"`            // Total monitored services
+	Failed   int           `json:"failed"`           // Failed services
+	Muted    int           `json:"muted"`            // Services excluded from the health status by silences
+	Missing  int           `json:"missing"`          // Expected services not found in the cluster
+	Impacted int           `json:"impacted"`         // Failed services impacted by a failed upstream service
 }
 
+// ServiceStatus is the health status of a service
+type ServiceStatus string
+
+// Service statuses
+const (
+	ServiceHealthy  ServiceStatus = "healthy"
+	ServiceFailed   ServiceStatus = "failed"
+	ServiceDegraded ServiceStatus = "degraded" // healthy, but with a warning
+	ServiceFlapping ServiceStatus = "flapping" // changing its health state too often
+	ServiceImpacted ServiceStatus = "impacted" // failed with a failed upstream service
+	ServiceMissing  ServiceStatus = "missing"  // expected, but not found in the cluster
+)
+
 type Service struct {
-	Name    string `json:"name"`    // The cluster name (ID)
-	Healthy bool   `json:"healthy"` // Cluster healthy state
+	Name       string        `json:"name"`                 // The cluster name (ID)
+	Namespace  string        `json:"namespace,omitempty"`  // The service namespace
+	Healthy    bool          `json:"healthy"`              // Cluster healthy state
+	Status     ServiceStatus `json:"status"`               // Detailed health status
+	LastCheck  time.Time     `json:"lastCheck"`            // Time of the last check
+	Error      string        `json:"error,omitempty"`      // Error of the last check, if failed
+	ErrorClass ErrorClass    `json:"errorClass,omitempty"` // Class of the error of the last check
+	Muted      bool          `json:"muted,omitempty"`      // Whether the service is silenced
+	Warning    string        `json:"warning,omitempty"`    // Why the service is degraded
+
+	// DependsOn lists the upstream services and ImpactedBy the failed ones causing the failure
+	DependsOn  []string `json:"dependsOn,omitempty"`
+	ImpactedBy []string `json:"impactedBy,omitempty"`
+
+	// CertExpiry is the earliest expiry of the certificate chain of the service, if checked over TLS
+	CertExpiry *time.Time `json:"certExpiry,omitempty"`
+
+	// Headers of the check request, the secret values are redacted
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Steps reports the last run of the steps of a synthetic check
+	Steps []StepResult `json:"steps,omitempty"`
+
+	// DiscoveredURL is the health URL chosen among the candidates, if discovered
+	DiscoveredURL string `json:"discoveredUrl,omitempty"`
+
+	// Components reports the parts of the service from its structured health response
+	Components []Component `json:"components,omitempty"`
 }
 
 // ClusterState describes the current cluster state
 type ClusterState struct {
 	Cluster  Cluster   `json:"cluster"`
 	Services []Service `json:"services"`
+
+	// Continue is the token of the next page of the services, if any
+	Continue string `json:"continue,omitempty"`
+
+	// Namespaces summarizes the status of each namespace
+	Namespaces []NamespaceStatus `json:"namespaces,omitempty"`
 }
 
 // Checker periodically checks availability of targets in the list
 type Checker struct {
-	ClusterID        string
-	Interval         time.Duration
-	FailureThreshold int
-	SuccessThreshold int
-	StateThreshold   int
-	Logger           *zap.Logger
+	ClusterID         string
+	Interval          time.Duration
+	FailureThreshold  int
+	SuccessThreshold  int
+	StateThreshold    int
+	MinTargets        int           // Minimum number of monitored services of a healthy cluster
+	EmptyPolicy       ClusterStatus // Status of the cluster without monitored services, healthy by default
+	EventBuffer       int           // Capacity of each event subscriber buffer
+	EventHistory      int           // Number of recent events kept for replay
+	Silences          []Silence
+	Settings          []ServiceSettings
+	Expected          []Expectation // Services that must exist in the cluster
+	Dependencies      []Dependency  // Upstream services of the services, in addition to the declared ones
+	CountImpacted     bool          // Whether the impacted services count as failed, otherwise each failure chain counts once
+	Store             StateStore    // Persists the state across restarts, if set
+	SnapshotInterval  time.Duration // Time between two consecutive state snapshots
+	CertWarning       time.Duration // Services with a certificate expiring within the window are degraded
+	CertCritical      time.Duration // Services with a certificate expiring within the window fail
+	FlapWindow        int           // Number of checks of the flap detection, disabled if zero
+	FlapHighThreshold int           // Percent of state changes above which a service flaps
+	FlapLowThreshold  int           // Percent of state changes below which a service stops flapping
+	RetryInterval     time.Duration // Time between the checks of a service changing its state, disabled if zero
+	Logger            *zap.Logger
 
 	done chan struct{}
 	mux  sync.Mutex
 
-	slogger      *zap.SugaredLogger
-	client       *http.Client
-	targets      map[string]*target
-	activeCount  int
-	healthyCount int
-	healthy      bool
-	added        chan *target
-	deleted      chan string
-	reports      chan *report
-	accessors    chan accessor
-	ready        bool
-	updates      chan struct{}
+	slogger       *zap.SugaredLogger
+	targets       map[string]*target
+	activeCount   int
+	healthyCount  int
+	mutedCount    int
+	healthy       bool
+	status        ClusterStatus
+	reason        string
+	added         chan *target
+	deleted       chan string
+	reports       chan *report
+	accessors     chan accessor
+	sources       map[string]bool
+	silences      map[string]*Silence
+	expected      map[string][]Expectation
+	dependencies  map[string][]string
+	settings      map[string]*ServiceSettings
+	impactedCount int
+	missing       map[string]bool
+	restored      map[string]TargetState
+	snapshots     chan *Snapshot
+	persisted     chan struct{}
+	finished      chan struct{}
+
+	namespaceThresholds map[string]int // thresholds of the namespaces overriding the cluster one
+	firstRound          bool           // whether the targets of the initial sync have all been probed
+
+	beatMux sync.Mutex
+	beats   map[string]*heartbeat
+
+	events        chan Event
+	subscribing   chan *subscriber
+	unsubscribing chan *subscriber
+	lastEventID   uint64
+}
+
+// TargetSpec describes a service to be checked
+type TargetSpec struct {
+	Name      string // Unique target name
+	URL       string // Full url to check including the path
+	Namespace string // Namespace of the service, if any
+	Labels    map[string]string
+	TLS       *TLSConfig     // TLS settings of the https checks, if any
+	Request   *RequestConfig // Headers and credentials of the check request, if any
+	Policy    *FailurePolicy // Failures that don't count, if any
+
+	// RetryInterval overrides the time between the checks of the service changing its state, if set
+	RetryInterval time.Duration
+
+	// DependsOn lists the names of the upstream services
+	DependsOn []string
+
+	// Steps checks the service with a sequence of requests instead of a single one, if set
+	Steps []Step
+
+	// StepsOnTargetHost fails the steps sending a request to another host than the one
+	// of the URL, e.g. for the steps set by the service annotations
+	StepsOnTargetHost bool
+
+	// Candidates are the URLs tried in order to discover the health URL when the URL is not set.
+	// The first one passing the status check is kept until it answers 404.
+	Candidates []string
+
+	// SilencedUntil mutes the target until the given time, if set
+	SilencedUntil time.Time
 }
 
 type accessor func(c *Checker)
 
+// housekeepingInterval is the time between the checks for expired silences
+const housekeepingInterval = time.Second
+
 type target struct {
 	name       string // name of the service
 	url        string // full url to check inlcuding the path
+	namespace  string // namespace of the service
+	labels     map[string]string
+	tls        *TLSConfig
+	request    *RequestConfig
+	policy     *FailurePolicy
+	retry      time.Duration // time between the checks while changing the state, if set
+	dependsOn  []string      // names of the upstream targets
+	impactedBy []string      // names of the failed upstream targets causing the failure
+	steps      []Step        // steps of the synthetic check, if any
+	stepsLocal bool          // whether the steps are restricted to the host of the url
+	candidates []string      // candidate URLs of the health URL discovery, if any
 	healthy    bool
+	muted      bool // whether the target is silenced
+	silence    time.Time
+	settled    bool   // whether the target has ever reached a success or failure threshold
+	flapping   bool   // whether the target changes its health state too often
+	flapHealth bool   // health state counted while flapping, the one before it started
+	results    []bool // results of the last checks of the flap detection, oldest first
+	probed     bool   // whether the target has been checked since it was added
+	update     bool   // whether the target replaces the one of the same name, if any
+	hidden     bool   // whether the failure has only been notified as impacted, so its recovery too
 	done       chan struct{}
 	lastReport *report
 
@@ -68,6 +223,9 @@ type target struct {
 	// if positive, it contains the number of consecutive successful checks;
 	// if negative, it contains the (negative) number of consecutive failed checks
 	state int64
+
+	// lastProbe is the start time (Unix ns) of the last check, accessed atomically
+	lastProbe int64
 }
 
 //Run starts the checker
@@ -76,23 +234,73 @@ func (c *Checker) Run() error {
 		return err
 	}
 
+	c.silences = make(map[string]*Silence)
+	for i := range c.Silences {
+		s := c.Silences[i]
+		if err := s.validate(time.Now()); err != nil {
+			return fmt.Errorf("invalid silence %d: %v", i, err)
+		}
+		s.configured = true
+		c.silences[s.ID] = &s
+	}
+
+	c.beats = make(map[string]*heartbeat)
+	c.Heartbeat("checker", loopHeartbeatAge)
+	c.Heartbeat("scheduler", loopHeartbeatAge)
+
+	for i := range c.Expected {
+		if err := c.Expected[i].validate(); err != nil {
+			return fmt.Errorf("invalid expected service %d: %v", i, err)
+		}
+	}
+	c.expected = map[string][]Expectation{"config": c.Expected}
+
+	c.settings = make(map[string]*ServiceSettings)
+	for i := range c.Settings {
+		s := &c.Settings[i]
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("invalid service settings %d: %v", i, err)
+		}
+		c.settings[s.Service] = s
+	}
+
+	c.dependencies = make(map[string][]string)
+	for i := range c.Dependencies {
+		d := &c.Dependencies[i]
+		if err := d.validate(); err != nil {
+			return fmt.Errorf("invalid dependency %d: %v", i, err)
+		}
+		c.dependencies[d.Service] = append(c.dependencies[d.Service], d.DependsOn...)
+	}
+
 	c.mux.Lock()
 	c.done = make(chan struct{})
+	c.finished = make(chan struct{})
 	c.mux.Unlock()
 
 	c.slogger = c.Logger.Sugar()
+	c.restored = make(map[string]TargetState)
+	if c.Store != nil {
+		c.restore()
+		c.snapshots = make(chan *Snapshot, 1)
+		c.persisted = make(chan struct{})
+		go c.persist()
+	}
 	c.targets = make(map[string]*target)
+	c.namespaceThresholds = make(map[string]int)
 	c.reports = make(chan *report)
 	c.added = make(chan *target)
 	c.deleted = make(chan string)
 	c.accessors = make(chan accessor)
-	c.client = &http.Client{
-		Timeout: calcTimeout(c.Interval),
-	}
-	c.healthy = true
-	c.ready = true
+	c.events = make(chan Event, eventQueueSize)
+	c.subscribing = make(chan *subscriber)
+	c.unsubscribing = make(chan *subscriber)
+	c.sources = make(map[string]bool)
+	c.status, c.reason = c.clusterStatus()
+	c.healthy = c.status == ClusterHealthy
 
 	go c.run()
+	go c.dispatch()
 	return nil
 }
 
@@ -107,6 +315,22 @@ func (c *Checker) validate() error {
 		- Only '-' and/or '.' special characters are allowed
 		- Must start/end with alphanumeric string only`)
 	}
+
+	switch c.EmptyPolicy {
+	case "":
+		c.EmptyPolicy = ClusterHealthy
+	case ClusterHealthy, ClusterUnhealthy, ClusterUnknown:
+	default:
+		return fmt.Errorf("invalid empty cluster policy %q, want one of healthy, unhealthy, unknown", c.EmptyPolicy)
+	}
+
+	if c.RetryInterval < 0 {
+		return fmt.Errorf("invalid retry interval %s", c.RetryInterval)
+	}
+
+	if high, low := c.flapThresholds(); high > 100 || low > high {
+		return fmt.Errorf("invalid flap thresholds, want low %.0f%% <= high %.0f%% <= 100%%", low, high)
+	}
 	return nil
 }
 
@@ -114,40 +338,87 @@ func calcTimeout(interval time.Duration) time.Duration {
 	return time.Duration(float64(interval) * 0.8)
 }
 
-// Stop stops the checker
+// Stop stops the checker and waits for the final state snapshot to be saved
 func (c *Checker) Stop() {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
 	if c.done != nil {
 		close(c.done)
+		<-c.finished
+		if c.persisted != nil {
+			<-c.persisted
+		}
 	}
 }
 
 // State reports about the current cluster state
 func (c *Checker) State() ClusterState {
-	result := make(chan *ClusterState, 1)
-	c.accessors <- func(c *Checker) {
-		cs := &ClusterState{
-			Cluster: Cluster{
-				Name:    c.ClusterID,
-				Healthy: c.healthy,
-				Total:   c.activeCount,
-				Failed:  c.activeCount - c.healthyCount,
-			},
-			Services: make([]Service, 0, c.activeCount),
-		}
-		for k, v := range c.targets {
-			if v.state != 0 {
-				cs.Services = append(cs.Services, Service{
-					Name:    k,
-					Healthy: v.healthy,
-				})
-			}
-		}
-		result <- cs
+	cs, _ := c.Query(ServiceQuery{Namespaces: true})
+	return cs
+}
+
+// cluster summarizes the current cluster state
+func (c *Checker) cluster() Cluster {
+	return Cluster{
+		Name:     c.ClusterID,
+		Healthy:  c.healthy,
+		Status:   c.status,
+		Reason:   c.reason,
+		Total:    c.activeCount,
+		Failed:   c.activeCount - c.healthyCount,
+		Muted:    c.mutedCount,
+		Missing:  len(c.missing),
+		Impacted: c.impactedCount,
+	}
+}
+
+// service reports about the current state of the checked target
+func (c *Checker) service(k string, v *target) Service {
+	svc := Service{
+		Name:          k,
+		Namespace:     v.namespace,
+		Healthy:       v.healthy,
+		Status:        serviceStatus(v),
+		LastCheck:     v.lastReport.ts,
+		Muted:         v.muted,
+		Headers:       v.request.Redacted(),
+		Steps:         v.lastReport.steps,
+		DiscoveredURL: v.lastReport.discovered,
+		Components:    v.lastReport.components,
+	}
+	if v.healthy {
+		svc.Warning = v.lastReport.warning
+	}
+	if len(v.impactedBy) > 0 {
+		svc.ImpactedBy = v.impactedBy
+	}
+	svc.DependsOn = append(append([]string(nil), v.dependsOn...), c.dependencies[k]...)
+	if v.lastReport.err != nil {
+		svc.Error = v.lastReport.err.Error()
+		svc.ErrorClass = v.lastReport.err.Class
+	}
+	if !v.lastReport.certExpiry.IsZero() {
+		expiry := v.lastReport.certExpiry
+		svc.CertExpiry = &expiry
+	}
+	return svc
+}
+
+// serviceStatus evaluates the status of the checked target
+func serviceStatus(v *target) ServiceStatus {
+	switch {
+	case len(v.impactedBy) > 0:
+		return ServiceImpacted
+	case v.flapping:
+		return ServiceFlapping
+	case !v.healthy:
+		return ServiceFailed
+	case v.lastReport.warning != "":
+		return ServiceDegraded
+	default:
+		return ServiceHealthy
 	}
-	return *<-result
 }
 
 // Healthy returns current cluster health state
@@ -159,22 +430,73 @@ func (c *Checker) Healthy() bool {
 	return <-result
 }
 
-// Ready gets the current readiness status
+// Ready gets the current readiness status.
+// The checker is ready once all the discovery sources completed their initial
+// sync, all their targets have been checked and the event queue is not stuck.
 func (c *Checker) Ready() bool {
-	result := make(chan bool, 1)
-	c.accessors <- func(c *Checker) {
-		result <- c.ready
-	}
-	return <-result
+	return passed(c.ReadinessChecks())
 }
 
 // Add adds the given service to the check list
 func (c *Checker) Add(name string, url string) {
-	if name == "" || url == "" {
+	c.AddTarget(TargetSpec{Name: name, URL: url})
+}
+
+// AddTarget adds the service described by the spec to the check list
+func (c *Checker) AddTarget(spec TargetSpec) {
+	if t := c.newTarget(spec); t != nil {
+		c.added <- t
+	}
+}
+
+// UpdateTarget replaces the service of the same name with the one described by the spec,
+// e.g. when its annotations change, or adds it if there is none. The health state of the
+// service is kept, and the new definition applies from its next check.
+func (c *Checker) UpdateTarget(spec TargetSpec) {
+	if t := c.newTarget(spec); t != nil {
+		t.update = true
+		c.added <- t
+	}
+}
+
+// newTarget validates the spec and creates its target, or returns nil if the spec is invalid
+func (c *Checker) newTarget(spec TargetSpec) *target {
+	if spec.Name == "" || (spec.URL == "" && len(spec.Steps) == 0 && len(spec.Candidates) == 0) {
 		c.slogger.Errorf("Invalid service definition")
-		return
+		return nil
+	}
+	if len(spec.Steps) > 0 {
+		if err := validateSteps(spec.URL, spec.Steps); err != nil {
+			c.slogger.Errorf("Invalid steps of service %s: %v", spec.Name, err)
+			return nil
+		}
+		spec.URL = first(spec.URL, spec.Steps[0].URL)
+	}
+	if s, ok := c.settings[spec.Name]; ok {
+		if s.request != nil {
+			spec.Request = s.request
+		}
+		if s.tls != nil {
+			spec.TLS = s.tls
+		}
+	}
+	return &target{
+		name:       spec.Name,
+		url:        spec.URL,
+		namespace:  spec.Namespace,
+		labels:     spec.Labels,
+		tls:        spec.TLS,
+		request:    spec.Request,
+		policy:     spec.Policy,
+		retry:      spec.RetryInterval,
+		dependsOn:  spec.DependsOn,
+		steps:      spec.Steps,
+		stepsLocal: spec.StepsOnTargetHost,
+		candidates: spec.Candidates,
+		silence:    spec.SilencedUntil,
+		done:       make(chan struct{}),
+		lastProbe:  time.Now().UnixNano(),
 	}
-	c.added <- &target{name: name, url: url, done: make(chan struct{})}
 }
 
 // Delete removes the given service from the check list
@@ -187,12 +509,39 @@ func (c *Checker) Delete(name string) {
 }
 
 func (c *Checker) addTarget(t *target) {
-	if _, ok := c.targets[t.name]; ok {
-		c.slogger.Errorf("Attempt to add already added target %s", t.name)
+	if old, ok := c.targets[t.name]; ok {
+		if !t.update {
+			c.slogger.Errorf("Attempt to add already added target %s", t.name)
+			return
+		}
+		c.replaceTarget(old, t)
 		return
 	}
 	c.slogger.Infof("Adding target %s", t.name)
 	c.targets[t.name] = t
+	c.restoreTarget(t)
+	c.publish(Event{Type: TargetAdded, Target: t.name, Namespace: t.namespace, Healthy: t.healthy})
+	c.silenceTarget(t, t.silence)
+	c.updateMuted(time.Now())
+	c.updateHealthStatus()
+	go c.newTargetLoop(t)
+}
+
+// replaceTarget swaps the target for its new definition without any event,
+// keeping its health state so that the cluster status doesn't change
+func (c *Checker) replaceTarget(old, t *target) {
+	close(old.done)
+
+	t.state, t.healthy, t.settled = old.state, old.healthy, old.settled
+	t.flapping, t.flapHealth, t.results = old.flapping, old.flapHealth, old.results
+	t.probed, t.lastReport = old.probed, old.lastReport
+	t.muted, t.impactedBy, t.hidden = old.muted, old.impactedBy, old.hidden
+	c.targets[t.name] = t
+	c.slogger.Infof("Updated target %s", t.name)
+
+	c.silenceTarget(t, t.silence)
+	c.updateMuted(time.Now())
+	c.updateHealthStatus()
 	go c.newTargetLoop(t)
 }
 
@@ -206,14 +555,8 @@ func (c *Checker) deleteTarget(url string) {
 	close(t.done)
 	delete(c.targets, url)
 	c.slogger.Infof("Removed target %s", url)
-
-	if t.state != 0 {
-		c.activeCount--
-		if t.healthy {
-			c.healthyCount--
-		}
-		c.updateHealthStatus()
-	}
+	c.publish(Event{Type: TargetRemoved, Target: url, Namespace: t.namespace, Healthy: t.healthy})
+	c.updateHealthStatus()
 }
 
 func (c *Checker) update(r *report) {
@@ -222,46 +565,136 @@ func (c *Checker) update(r *report) {
 		c.slogger.Warnf("Received report from unregistered target %s", r.name)
 		return
 	}
-	if t.state == 0 {
-		c.activeCount++
+	if r.target != nil && r.target != t {
+		c.slogger.Debugf("Ignoring report of the replaced definition of target %s", r.name)
+		return
 	}
 	t.lastReport = r
+	t.probed = true
+	c.updateFirstRound()
+	settled := c.updateFlapping(t, r.err == nil)
 
 	if r.err == nil {
 		if t.state < 0 {
 			t.state = 0
 		}
 		t.state++
-		if t.state >= int64(c.SuccessThreshold) && !t.healthy {
+		if t.state >= int64(c.SuccessThreshold) && (!t.healthy || !t.settled) {
 			t.healthy = true
-			c.healthyCount++
+			t.settled = true
+			c.publish(Event{
+				Type:       TargetHealthy,
+				Target:     t.name,
+				Namespace:  t.namespace,
+				Healthy:    true,
+				Suppressed: t.muted || t.flapping || t.hidden,
+			})
+			t.hidden = false
 		}
 	} else {
 		if t.state > 0 {
 			t.state = 0
 		}
 		t.state--
-		if t.state <= int64(-c.FailureThreshold) && t.healthy {
+		if t.state <= int64(-c.FailureThreshold) && (t.healthy || !t.settled) {
 			t.healthy = false
-			c.healthyCount--
+			t.settled = true
+			c.updateImpacts()
+			e := Event{
+				Type:       TargetUnhealthy,
+				Target:     t.name,
+				Namespace:  t.namespace,
+				Status:     string(ServiceFailed),
+				Error:      r.err.Error(),
+				ErrorClass: r.err.Class,
+				Suppressed: t.muted || t.flapping,
+			}
+			if len(t.impactedBy) > 0 {
+				// Only the root causes are notified
+				e.Status = string(ServiceImpacted)
+				e.Suppressed = true
+				t.hidden = true
+			}
+			c.publish(e)
 		}
 	}
 
+	if settled {
+		c.settle(t)
+	}
+
 	c.updateHealthStatus()
 	c.slogger.Infof("Report from %s: s:%d, h:%t, err:%v", t.url, t.state, t.healthy, r.err)
-	if c.updates != nil {
-		select {
-		case c.updates <- struct{}{}:
+}
+
+func (c *Checker) updateHealthStatus() {
+	c.updateImpacts()
+
+	c.activeCount, c.healthyCount, c.mutedCount, c.impactedCount = 0, 0, 0, 0
+	for _, t := range c.targets {
+		switch {
+		case t.state == 0:
+		case t.muted:
+			c.mutedCount++
+		case len(t.impactedBy) > 0:
+			c.impactedCount++
+			if c.CountImpacted {
+				c.activeCount++
+			}
 		default:
+			c.activeCount++
+			if t.countedHealthy() {
+				c.healthyCount++
+			}
 		}
 	}
+
+	c.updateMissing()
+	c.activeCount += len(c.missing)
+
+	status, reason := c.clusterStatus()
+	c.reason = reason
+	if status != c.status {
+		c.status = status
+		c.healthy = status == ClusterHealthy
+		c.publish(Event{Type: ClusterStatusChanged, Healthy: c.healthy, Status: string(status), Error: reason})
+	}
 }
 
-func (c *Checker) updateHealthStatus() {
-	c.healthy = calcHealthStatus(c.activeCount, c.healthyCount, c.StateThreshold)
+// clusterStatus evaluates the cluster status from the counts of the monitored services
+func (c *Checker) clusterStatus() (ClusterStatus, string) {
+	switch {
+	case c.activeCount < c.MinTargets:
+		return ClusterUnhealthy, fmt.Sprintf("%d monitored services, at least %d expected", c.activeCount, c.MinTargets)
+	case c.activeCount == 0:
+		if c.EmptyPolicy == ClusterHealthy {
+			return ClusterHealthy, ""
+		}
+		return c.EmptyPolicy, "no monitored services"
+	case !calcHealthStatus(c.activeCount, c.healthyCount, c.StateThreshold):
+		return ClusterUnhealthy, fmt.Sprintf("%d of %d services failed", c.activeCount-c.healthyCount, c.activeCount)
+	default:
+		return ClusterHealthy, ""
+	}
 }
 
 func (c *Checker) run() {
+	defer close(c.finished)
+
+	housekeeping := time.NewTicker(housekeepingInterval)
+	defer housekeeping.Stop()
+
+	var snapshots <-chan time.Time
+	if c.Store != nil {
+		interval := c.SnapshotInterval
+		if interval <= 0 {
+			interval = defaultSnapshotInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		snapshots = ticker.C
+	}
+
 Loop:
 	for {
 		select {
@@ -273,49 +706,149 @@ Loop:
 			c.update(r)
 		case a := <-c.accessors:
 			a(c)
+		case now := <-housekeeping.C:
+			c.updateMuted(now)
+			c.beatLoops(now)
+		case <-snapshots:
+			c.queueSnapshot()
 		case <-c.done:
 			c.slogger.Info("Stopping all target loops")
 			for _, c := range c.targets {
 				close(c.done)
 			}
+			if c.Store != nil {
+				c.queueSnapshot()
+				close(c.snapshots)
+			}
 			break Loop
 		}
 	}
 }
 
+// newTargetLoop checks the target until it is deleted or replaced. Its client,
+// request and pacer are only used by this loop, so they need no synchronization.
 func (c *Checker) newTargetLoop(t *target) {
-	ticker := time.NewTicker(c.Interval)
-	defer ticker.Stop()
+	// The timer is armed after each check with the time before the next one
+	timer := time.NewTimer(c.Interval)
+	timer.Stop()
+	defer timer.Stop()
+
+	client := &targetClient{tls: t.tls}
+	request := &targetRequest{url: t.url, config: t.request}
+	pacer := &pacer{
+		interval:         c.Interval,
+		retry:            c.RetryInterval,
+		failureThreshold: c.FailureThreshold,
+		successThreshold: c.SuccessThreshold,
+	}
+	if t.retry != 0 {
+		pacer.retry = t.retry
+	}
 
+	interval := c.Interval
 Loop:
 	for {
 		ts := time.Now()
-		resp, err := c.client.Get(t.url)
-		if err == nil {
-			resp.Body.Close() // TODO: Do we need to drain the body before closing?
-			if resp.StatusCode != http.StatusOK {
-				err = fmt.Errorf("Status %d", resp.StatusCode)
-			}
-		}
+		atomic.StoreInt64(&t.lastProbe, ts.UnixNano())
+		r := c.check(client, request, t, ts, calcTimeout(interval))
+		c.reports <- r
 
-		c.reports <- &report{
-			name: t.name,
-			ts:   ts,
-			err:  err,
-		}
+		interval = pacer.next(r.err == nil)
+		timer.Reset(time.Until(ts.Add(interval)))
 
 		select {
 		case <-t.done:
 			break Loop
-		case <-ticker.C:
+		case <-timer.C:
+		}
+	}
+}
+
+// check probes the target once within the timeout
+func (c *Checker) check(client *targetClient, request *targetRequest, t *target, now time.Time, timeout time.Duration) *report {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	r := &report{
+		name:   t.name,
+		target: t,
+		ts:     now,
+	}
+
+	resp, err := c.discoverOrProbe(ctx, client, request, t, r)
+	if err == nil {
+		var health *healthResponse
+		if len(t.steps) == 0 {
+			health = readHealth(resp)
+		}
+		resp.Body.Close() // TODO: Do we need to drain the body before closing?
+		// The steps of the synthetic checks have already checked their status codes
+		if len(t.steps) == 0 {
+			err = checkStatus(resp.StatusCode)
 		}
+		if health != nil {
+			err = checkHealth(r, health, err)
+		}
+		if resp.TLS != nil {
+			r.certExpiry = certExpiry(resp.TLS.PeerCertificates)
+			if certErr := c.checkCertExpiry(r, now); err == nil {
+				err = certErr
+			}
+		}
+	}
+	if err != nil {
+		checkErr := classify(err)
+		if t.policy.ignores(checkErr) {
+			c.slogger.Debugf("Ignoring %s failure of target %s: %v", checkErr.Class, t.name, err)
+		} else {
+			r.err = checkErr
+		}
+	}
+	return r
+}
+
+// checkStatus fails the health responses with another status code than 200
+func checkStatus(code int) error {
+	if code != http.StatusOK {
+		return statusError(code)
+	}
+	return nil
+}
+
+// probe sends the check request to the target, or runs the steps of its synthetic check
+func (c *Checker) probe(ctx context.Context, client *targetClient, request *targetRequest, t *target, r *report) (*http.Response, error) {
+	now := r.ts
+	httpClient, err := client.get(now)
+	if httpClient == nil {
+		return nil, err
+	}
+	if err != nil {
+		c.slogger.Warnf("Keeping the previous TLS settings of target %s: %v", t.name, err)
+	}
+
+	req, err := request.get(now)
+	if req == nil {
+		return nil, err
+	}
+	if err != nil {
+		c.slogger.Warnf("Keeping the previous request values of target %s: %v", t.name, err)
+	}
+	if len(t.steps) > 0 {
+		return c.runSteps(ctx, httpClient, req, t, r)
 	}
+	return httpClient.Do(req.WithContext(ctx))
 }
 
 type report struct {
-	name string
-	ts   time.Time
-	err  error
+	name       string
+	target     *target // probed target, which may have been replaced since
+	ts         time.Time
+	err        *CheckError
+	warning    string    // why the target is degraded, if it is healthy
+	certExpiry time.Time // earliest expiry of the certificate chain, zero without TLS
+	steps      []StepResult
+	discovered string      // health URL chosen by the discovery, if any
+	components []Component // components of the structured health response, if any
 }
 
 func calcHealthStatus(total, healthy, threshold int) bool {
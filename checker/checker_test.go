@@ -1,10 +1,23 @@
 package checker
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"io"
+	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -40,52 +53,1480 @@ func TestHealthyEndpoint(t *testing.T) {
 		StateThreshold:   100,
 		Logger:           zap.NewNop(),
 	}
-	checker.updates = make(chan struct{}, 1)
 	if err := checker.Run(); err != nil {
 		t.Errorf("got error %v", err)
 		return
 	}
 	defer checker.Stop()
 
+	events, cancel := checker.Subscribe(TypeFilter(TargetHealthy, TargetUnhealthy))
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK\n")
+	}))
+	defer server.Close()
+
+	checker.Add("test", server.URL)
+	<-events
+
+	if !checker.Healthy() {
+		t.Error("checker must be healthy")
+	}
+}
+
+func TestUnhealthyEndpoint(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Errorf("got error %v", err)
+		return
+	}
+	defer checker.Stop()
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetHealthy, TargetUnhealthy))
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	checker.Add("test", server.URL)
+	<-events
+
+	if checker.Healthy() {
+		t.Error("checker must be unhealthy")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Errorf("got error %v", err)
+		return
+	}
+	defer checker.Stop()
+
+	events, cancel := checker.Subscribe(nil)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	checker.Add("test", server.URL)
+
+	want := []EventType{TargetAdded, TargetUnhealthy, ClusterStatusChanged}
+	for i, typ := range want {
+		e := <-events
+		if e.Type != typ {
+			t.Errorf("event %d: want type %s, got %s", i, typ, e.Type)
+		}
+		if e.ID != uint64(i+1) {
+			t.Errorf("event %d: want ID %d, got %d", i, i+1, e.ID)
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestSubscribeFrom(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Errorf("got error %v", err)
+		return
+	}
+	defer checker.Stop()
+
+	live, cancel := checker.Subscribe(TypeFilter(TargetAdded))
+	defer cancel()
+
+	checker.AddTarget(TargetSpec{Name: "s1.ns1", Namespace: "ns1", URL: "http://127.0.0.1:0"})
+	checker.AddTarget(TargetSpec{Name: "s2.ns2", Namespace: "ns2", URL: "http://127.0.0.1:0"})
+	checker.AddTarget(TargetSpec{Name: "s3.ns2", Namespace: "ns2", URL: "http://127.0.0.1:0"})
+	for i := 0; i < 3; i++ {
+		<-live
+	}
+
+	ns2 := NamespaceFilter("ns2")
+	events, cancelReplay := checker.SubscribeFrom(1, func(e Event) bool {
+		return e.Type == TargetAdded && ns2(e)
+	})
+	defer cancelReplay()
+
+	for _, want := range []string{"s2.ns2", "s3.ns2"} {
+		if got := (<-events).Target; got != want {
+			t.Errorf("want replayed event of %s, got %s", want, got)
+		}
+	}
+}
+
+func TestSubscriberDropsOldest(t *testing.T) {
+	s := &subscriber{events: make(chan Event, 2)}
+	for i := 1; i <= 5; i++ {
+		s.deliver(Event{ID: uint64(i)})
+	}
+
+	if want, got := 3, s.dropped; want != got {
+		t.Errorf("want %d dropped events, got %d", want, got)
+	}
+	for _, want := range []uint64{4, 5} {
+		if got := (<-s.events).ID; got != want {
+			t.Errorf("want event %d, got %d", want, got)
+		}
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Errorf("got error %v", err)
+		return
+	}
+	defer checker.Stop()
+
+	if !checker.Ready() {
+		t.Error("checker without sources and targets must be ready")
+	}
+
+	checker.AddSource("test")
+	if checker.Ready() {
+		t.Error("checker must not be ready before the initial sync")
+	}
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetHealthy))
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK\n")
+	}))
+	defer server.Close()
+
+	checker.Add("test", server.URL)
+	checker.SourceSynced("test")
+	<-events
+
+	for _, check := range checker.ReadinessChecks() {
+		if !check.OK {
+			t.Errorf("want check %s passed, got %q", check.Name, check.Message)
+		}
+	}
+
+	// A target added after the first probe round doesn't make the checker unready
+	checker.Add("later", server.URL)
+	checker.State()
+	for _, check := range checker.ReadinessChecks() {
+		if !check.OK {
+			t.Errorf("want check %s passed after adding a target, got %q", check.Name, check.Message)
+		}
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Errorf("got error %v", err)
+		return
+	}
+	defer checker.Stop()
+
+	if !checker.Alive() {
+		t.Error("started checker must be alive")
+	}
+
+	checker.Heartbeat("source", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	for _, check := range checker.LivenessChecks() {
+		if want, got := check.Name != "source", check.OK; want != got {
+			t.Errorf("check %s: want OK %t, got %t", check.Name, want, got)
+		}
+	}
+	if checker.Alive() {
+		t.Error("checker with a stale heartbeat must not be alive")
+	}
+}
+
+func TestMissingService(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+		Expected: []Expectation{
+			{Name: "auth.platform"},
+			{Namespace: "ingress", Selector: "app=gateway"},
+		},
+	}
+	if err := checker.Run(); err != nil {
+		t.Errorf("got error %v", err)
+		return
+	}
+	defer checker.Stop()
+
+	checker.AddSource("test")
+	if !checker.Healthy() {
+		t.Error("services must not be missing before the initial sync")
+	}
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetUnhealthy))
+	defer cancel()
+
+	checker.SourceSynced("test")
+	for _, want := range []string{"auth.platform", "namespace=ingress,selector=app=gateway"} {
+		if e := <-events; e.Target != want || e.Status != string(ServiceMissing) {
+			t.Errorf("want missing %s, got %s %s", want, e.Status, e.Target)
+		}
+	}
+
+	state := checker.State()
+	if want, got := 2, state.Cluster.Missing; want != got {
+		t.Errorf("want %d missing services, got %d", want, got)
+	}
+	if want, got := 2, state.Cluster.Failed; want != got {
+		t.Errorf("want %d failed services, got %d", want, got)
+	}
+	if state.Cluster.Healthy {
+		t.Error("checker with missing services must be unhealthy")
+	}
+
+	checker.AddTarget(TargetSpec{Name: "gw.ingress", Namespace: "ingress", Labels: map[string]string{"app": "gateway"}, URL: "http://127.0.0.1:1"})
+	if err := checker.SetExpected("config", []Expectation{{Namespace: "ingress", Selector: "app=gateway"}}); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if want, got := 0, checker.State().Cluster.Missing; want != got {
+		t.Errorf("want %d missing services, got %d", want, got)
+	}
+}
+
+func TestUpdateTarget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK\n")
+	}))
+	defer server.Close()
+
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+		Expected:         []Expectation{{Name: "web.shop"}},
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer checker.Stop()
+
+	healthy, cancelHealthy := checker.Subscribe(TypeFilter(TargetHealthy))
+	defer cancelHealthy()
+	checker.AddTarget(TargetSpec{Name: "web.shop", Namespace: "shop", URL: server.URL + "/healthz"})
+	<-healthy
+
+	events, cancel := checker.Subscribe(nil)
+	defer cancel()
+	checker.UpdateTarget(TargetSpec{Name: "web.shop", Namespace: "shop", URL: server.URL + "/ready"})
+	checker.UpdateTarget(TargetSpec{Name: "api.shop", Namespace: "shop", URL: server.URL + "/healthz"})
+
+	state := checker.State()
+	if len(state.Services) != 1 || !state.Services[0].Healthy || !state.Cluster.Healthy {
+		t.Errorf("want the updated service still healthy, got %+v", state)
+	}
+
+	if e := <-events; e.Type != TargetAdded || e.Target != "api.shop" {
+		t.Errorf("want only the added event of the new service, got %s %s", e.Type, e.Target)
+	}
+}
+
+func TestSilencedTarget(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+		Silences: []Silence{
+			{Namespace: "ns1", EndsAt: time.Now().Add(time.Hour), CreatedBy: "test", Comment: "deploy"},
+		},
+	}
+	if err := checker.Run(); err != nil {
+		t.Errorf("got error %v", err)
+		return
+	}
+	defer checker.Stop()
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetUnhealthy))
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	checker.AddTarget(TargetSpec{Name: "test.ns1", Namespace: "ns1", URL: server.URL})
+	if e := <-events; !e.Suppressed {
+		t.Error("transition of a silenced target must be suppressed")
+	}
+
+	state := checker.State()
+	if !state.Cluster.Healthy {
+		t.Error("silenced failures must not affect the cluster status")
+	}
+	if want, got := 1, state.Cluster.Muted; want != got {
+		t.Errorf("want %d muted services, got %d", want, got)
+	}
+
+	silences := checker.ListSilences()
+	if len(silences) != 1 {
+		t.Fatalf("want 1 silence, got %d", len(silences))
+	}
+	if !checker.DeleteSilence(silences[0].ID) {
+		t.Error("want silence deleted")
+	}
+	if checker.Healthy() {
+		t.Error("checker must be unhealthy once the silence is removed")
+	}
+}
+
+func TestSilenceMatches(t *testing.T) {
+	target := &target{name: "web.shop", namespace: "shop", labels: map[string]string{"app": "web", "tier": "front"}}
+
+	cases := []struct {
+		name    string
+		silence Silence
+		matches bool
+	}{
+		{"Name", Silence{Name: "web.shop"}, true},
+		{"OtherName", Silence{Name: "db.shop"}, false},
+		{"Namespace", Silence{Namespace: "shop"}, true},
+		{"OtherNamespace", Silence{Namespace: "platform"}, false},
+		{"Selector", Silence{Selector: "app=web"}, true},
+		{"SelectorSet", Silence{Selector: "tier in (front,back)"}, true},
+		{"OtherSelector", Silence{Selector: "app!=web"}, false},
+		{"AllCriteria", Silence{Name: "web.shop", Namespace: "shop", Selector: "app=web"}, true},
+		{"SomeCriteria", Silence{Name: "web.shop", Namespace: "platform"}, false},
+	}
+
+	now := time.Now()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := c.silence
+			s.EndsAt = now.Add(time.Hour)
+			s.CreatedBy = "test"
+			s.Comment = "test"
+			if err := s.validate(now); err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if want, got := c.matches, s.matches(target); want != got {
+				t.Errorf("want match %t, got %t", want, got)
+			}
+		})
+	}
+}
+
+func TestSilenceValidation(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name    string
+		silence Silence
+	}{
+		{"NoCriteria", Silence{EndsAt: now.Add(time.Hour), CreatedBy: "test", Comment: "test"}},
+		{"NoCreator", Silence{Name: "s", EndsAt: now.Add(time.Hour), Comment: "test"}},
+		{"NoComment", Silence{Name: "s", EndsAt: now.Add(time.Hour), CreatedBy: "test"}},
+		{"Ended", Silence{Name: "s", EndsAt: now.Add(-time.Hour), CreatedBy: "test", Comment: "test"}},
+		{"InvalidSelector", Silence{Selector: "a=(", EndsAt: now.Add(time.Hour), CreatedBy: "test", Comment: "test"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.silence.validate(now); err == nil {
+				t.Error("want error")
+			}
+		})
+	}
+}
+
+func TestAddSilenceID(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+		Silences: []Silence{
+			{ID: "deploy", Namespace: "ns1", EndsAt: time.Now().Add(time.Hour), CreatedBy: "test", Comment: "deploy"},
+		},
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer checker.Stop()
+
+	silence := Silence{Namespace: "ns2", EndsAt: time.Now().Add(time.Hour), CreatedBy: "test", Comment: "test"}
+	withID := silence
+	withID.ID = "deploy"
+	if _, err := checker.AddSilence(withID); err == nil {
+		t.Error("want the silence with an ID refused")
+	}
+	added, err := checker.AddSilence(silence)
+	if err != nil || added.ID == "" {
+		t.Fatalf("got silence %+v and error %v, want a generated ID", added, err)
+	}
+
+	silences := checker.ListSilences()
+	if len(silences) != 2 {
+		t.Fatalf("want 2 silences, got %d", len(silences))
+	}
+	for _, s := range silences {
+		if s.ID == "deploy" && s.Namespace != "ns1" {
+			t.Errorf("got silence %+v, want the configured one kept", s)
+		}
+	}
+}
+
+type memoryStore struct {
+	snapshot *Snapshot
+}
+
+func (m *memoryStore) Load() (*Snapshot, error) {
+	return m.snapshot, nil
+}
+
+func (m *memoryStore) Save(s *Snapshot) error {
+	m.snapshot = s
+	return nil
+}
+
+func TestRestoreState(t *testing.T) {
+	store := &memoryStore{
+		snapshot: &Snapshot{
+			Time: time.Now(),
+			Targets: map[string]TargetState{
+				"test":    {State: 5, Healthy: true, Settled: true, LastCheck: time.Now()},
+				"missing": {State: -3, Settled: true, LastCheck: time.Now(), Error: "Status 500"},
+			},
+			Silences: []Silence{
+				{ID: "s1", Name: "other", EndsAt: time.Now().Add(time.Hour), CreatedBy: "test", Comment: "test"},
+			},
+		},
+	}
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Minute,
+		FailureThreshold: 2,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Store:            store,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Errorf("got error %v", err)
+		return
+	}
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetAdded))
+	defer cancel()
+
+	checker.Add("test", "http://127.0.0.1:1")
+	<-events
+
+	state := checker.State()
+	if want, got := 1, state.Cluster.Total; want != got {
+		t.Errorf("want %d restored services, got %d", want, got)
+	}
+	if len(state.Services) != 1 || !state.Services[0].Healthy {
+		t.Errorf("want restored healthy service, got %v", state.Services)
+	}
+	if want, got := 1, len(checker.ListSilences()); want != got {
+		t.Errorf("want %d restored silences, got %d", want, got)
+	}
+
+	checker.Stop()
+
+	saved := store.snapshot.Targets
+	if _, ok := saved["test"]; !ok {
+		t.Error("want state of the added target saved")
+	}
+	if want, got := int64(-3), saved["missing"].State; want != got {
+		t.Errorf("want state %d of the target not added yet saved, got %d", want, got)
+	}
+}
+
+func TestRestoreDeletedTargets(t *testing.T) {
+	store := &memoryStore{
+		snapshot: &Snapshot{
+			Time: time.Now(),
+			Targets: map[string]TargetState{
+				"deleted": {State: 5, Healthy: true, Settled: true, LastCheck: time.Now()},
+			},
+		},
+	}
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Minute,
+		FailureThreshold: 2,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Store:            store,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	checker.AddSource("k8s")
+	checker.AddSource("namespaces")
+	checker.SourceSynced("k8s")
+	done := make(chan int)
+	checker.accessors <- func(c *Checker) { done <- len(c.restored) }
+	if restored := <-done; restored != 1 {
+		t.Errorf("got %d restored states before the sync completed, want 1", restored)
+	}
+
+	checker.SourceSynced("namespaces")
+	checker.Stop()
+
+	if _, ok := store.snapshot.Targets["deleted"]; ok {
+		t.Error("want the state of the target not added again by the sync dropped")
+	}
+}
+
+func TestRestoreConfiguredSilences(t *testing.T) {
+	endsAt := time.Now().Add(time.Hour)
+	configured := Silence{Namespace: "payments", EndsAt: endsAt, CreatedBy: "ops", Comment: "migration"}
+	store := &memoryStore{
+		snapshot: &Snapshot{
+			Time: time.Now(),
+			Silences: []Silence{
+				// Copy of the configured silence saved with a generated ID by an older version
+				{ID: "0123456789abcdef", Namespace: "payments", EndsAt: endsAt, CreatedBy: "ops", Comment: "migration"},
+				{ID: "api", Name: "web.shop", EndsAt: endsAt, CreatedBy: "dev", Comment: "deploy"},
+			},
+		},
+	}
+
+	restart := func(silences ...Silence) []Silence {
+		checker := &Checker{
+			ClusterID:        "abc",
+			Interval:         1 * time.Minute,
+			FailureThreshold: 2,
+			SuccessThreshold: 1,
+			StateThreshold:   100,
+			Store:            store,
+			Silences:         silences,
+			Logger:           zap.NewNop(),
+		}
+		if err := checker.Run(); err != nil {
+			t.Fatalf("got error %v", err)
+		}
+		defer checker.Stop()
+		return checker.ListSilences()
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := restart(configured); len(got) != 2 {
+			t.Fatalf("restart %d: want the configured and the API silences, got %v", i, got)
+		}
+	}
+	for _, s := range store.snapshot.Silences {
+		if s.ID != "api" {
+			t.Errorf("want only the API silence saved, got %v", s)
+		}
+	}
+
+	if got := restart(); len(got) != 1 || got[0].ID != "api" {
+		t.Errorf("want the silence removed from the configuration dropped, got %v", got)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store := &FileStore{Path: filepath.Join(t.TempDir(), "state.json")}
+
+	s, err := store.Load()
+	if err != nil || s != nil {
+		t.Fatalf("want no snapshot, got %v, %v", s, err)
+	}
+
+	want := &Snapshot{
+		Time: time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC),
+		Targets: map[string]TargetState{
+			"test": {State: -2, Settled: true, LastCheck: time.Date(2030, 1, 2, 15, 4, 0, 0, time.UTC), Error: "Status 500"},
+		},
+		Silences: []Silence{},
+	}
+	if err := store.Save(want); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestTargetTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK\n")
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		tls  *TLSConfig
+		ok   bool
+	}{
+		{"Untrusted", nil, false},
+		{"CA file", &TLSConfig{Source: &FileTLSSource{CAFile: caFile}}, true},
+		{"Insecure", &TLSConfig{InsecureSkipVerify: true}, true},
+		{"Server name", &TLSConfig{ServerName: "example.com", Source: &FileTLSSource{CAFile: caFile}}, true},
+		{"Wrong server name", &TLSConfig{ServerName: "example.org", Source: &FileTLSSource{CAFile: caFile}}, false},
+		{"Missing file", &TLSConfig{Source: &FileTLSSource{CAFile: caFile + ".missing"}}, false},
+		{"Invalid client cert", &TLSConfig{Source: &FileTLSSource{CAFile: caFile, CertFile: caFile}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &targetClient{tls: tt.tls}
+			httpClient, err := client.get(time.Now())
+			if err == nil {
+				var resp *http.Response
+				if resp, err = httpClient.Get(server.URL); err == nil {
+					resp.Body.Close()
+				}
+			}
+			if (err == nil) != tt.ok {
+				t.Errorf("got error %v, want success %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+type countingTLSSource struct {
+	loads    int
+	material *TLSMaterial
+	err      error
+}
+
+func (s *countingTLSSource) Load() (*TLSMaterial, error) {
+	s.loads++
+	return s.material, s.err
+}
+
+func TestTargetClientReload(t *testing.T) {
+	source := &countingTLSSource{material: &TLSMaterial{}}
+	client := &targetClient{tls: &TLSConfig{Source: source}}
+
+	now := time.Now()
+	first, err := client.get(now)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if c, _ := client.get(now.Add(tlsReloadInterval / 2)); c != first || source.loads != 1 {
+		t.Errorf("material reloaded before the reload interval, loads %d", source.loads)
+	}
+
+	if c, _ := client.get(now.Add(tlsReloadInterval)); c != first || source.loads != 2 {
+		t.Errorf("client rebuilt for unchanged material, loads %d", source.loads)
+	}
+
+	source.err = errors.New("secret not found")
+	if c, err := client.get(now.Add(2 * tlsReloadInterval)); c != first || err == nil {
+		t.Errorf("got client %p and error %v, want the previous client and an error", c, err)
+	}
+
+	source.err = nil
+	source.material = &TLSMaterial{CA: []byte("invalid")}
+	if c, err := client.get(now.Add(3 * tlsReloadInterval)); c != first || err == nil {
+		t.Errorf("got client %p and error %v, want the previous client and an error", c, err)
+	}
+
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	source.material = &TLSMaterial{CA: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})}
+	if c, err := client.get(now.Add(4 * tlsReloadInterval)); c == first || err != nil {
+		t.Errorf("got error %v, want a new client for the rotated material", err)
+	}
+}
+
+func TestTargetRequest(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("HEALTHCAT_TEST_KEY", "env-key")
+	defer os.Unsetenv("HEALTHCAT_TEST_KEY")
+
+	tests := []struct {
+		name   string
+		config *RequestConfig
+		want   map[string]string
+		ok     bool
+	}{
+		{"None", nil, map[string]string{"Host": "example.com:80"}, true},
+		{
+			"Headers",
+			&RequestConfig{Headers: map[string]ValueSource{
+				"Host":         Literal("api.example.com"),
+				"x-health-key": EnvValue("HEALTHCAT_TEST_KEY"),
+			}},
+			map[string]string{"Host": "api.example.com", "X-Health-Key": "env-key"},
+			true,
+		},
+		{
+			"Bearer token",
+			&RequestConfig{BearerToken: FileValue(tokenFile)},
+			map[string]string{"Authorization": "Bearer file-token"},
+			true,
+		},
+		{
+			"Basic auth",
+			&RequestConfig{BasicAuth: &BasicAuth{Username: "user", Password: Literal("pass")}},
+			map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+			true,
+		},
+		{"Missing env", &RequestConfig{BearerToken: EnvValue("HEALTHCAT_TEST_MISSING")}, nil, false},
+		{"Missing file", &RequestConfig{BearerToken: FileValue(tokenFile + ".missing")}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &targetRequest{url: "http://example.com:80/healthz", config: tt.config}
+			req, err := request.get(time.Now())
+			if (err == nil) != tt.ok {
+				t.Fatalf("got error %v, want success %t", err, tt.ok)
+			}
+			if err != nil {
+				return
+			}
+
+			got := map[string]string{"Host": req.Host}
+			for name := range req.Header {
+				got[name] = req.Header.Get(name)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("got %s %q, want %q", name, got[name], value)
+				}
+			}
+		})
+	}
+}
+
+func TestTargetRequestReload(t *testing.T) {
+	os.Setenv("HEALTHCAT_TEST_TOKEN", "first")
+	defer os.Unsetenv("HEALTHCAT_TEST_TOKEN")
+
+	request := &targetRequest{url: "http://example.com/", config: &RequestConfig{BearerToken: EnvValue("HEALTHCAT_TEST_TOKEN")}}
+	now := time.Now()
+	request.get(now)
+
+	os.Setenv("HEALTHCAT_TEST_TOKEN", "second")
+	if req, _ := request.get(now.Add(credentialReloadInterval / 2)); req.Header.Get("Authorization") != "Bearer first" {
+		t.Errorf("token read again before the reload interval")
+	}
+	if req, _ := request.get(now.Add(credentialReloadInterval)); req.Header.Get("Authorization") != "Bearer second" {
+		t.Errorf("rotated token not picked up")
+	}
+
+	os.Unsetenv("HEALTHCAT_TEST_TOKEN")
+	req, err := request.get(now.Add(2 * credentialReloadInterval))
+	if err == nil || req == nil || req.Header.Get("Authorization") != "Bearer second" {
+		t.Errorf("got error %v, want the previous token and an error", err)
+	}
+}
+
+func TestRequestRedacted(t *testing.T) {
+	config := &RequestConfig{
+		Headers: map[string]ValueSource{
+			"host":         Literal("api.example.com"),
+			"X-Health-Key": Literal("key"),
+			"X-Tenant":     EnvValue("TENANT"),
+		},
+		BearerToken: Literal("token"),
+	}
+	want := map[string]string{
+		"Host":          "api.example.com",
+		"X-Health-Key":  redacted,
+		"X-Tenant":      redacted,
+		"Authorization": redacted,
+	}
+	if got := config.Redacted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var none *RequestConfig
+	if got := none.Redacted(); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+}
+
+func TestServiceSettings(t *testing.T) {
+	os.Setenv("HEALTHCAT_TEST_KEY", "env-key")
+	defer os.Unsetenv("HEALTHCAT_TEST_KEY")
+
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Settings: []ServiceSettings{{
+			Service: "test",
+			Request: &RequestSettings{Headers: map[string]ValueRef{"X-Health-Key": {Env: "HEALTHCAT_TEST_KEY"}}},
+		}},
+		Logger: zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer checker.Stop()
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetHealthy, TargetUnhealthy))
+	defer cancel()
+
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Health-Key") != "env-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	checker.AddTarget(TargetSpec{Name: "test", URL: server.URL, Request: &RequestConfig{Headers: map[string]ValueSource{"X-Health-Key": Literal("annotation")}}})
+	if e := <-events; e.Type != TargetHealthy {
+		t.Errorf("got event %v, want the settings applied in place of the annotations", e.Type)
+	}
+}
+
+func TestServiceSettingsTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK\n")
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Settings:         []ServiceSettings{{Service: "test", TLS: &TLSSettings{CAFile: caFile, ServerName: "example.com"}}},
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer checker.Stop()
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetHealthy, TargetUnhealthy))
+	defer cancel()
+
+	checker.AddTarget(TargetSpec{Name: "test", URL: server.URL, TLS: &TLSConfig{ServerName: "example.org"}})
+	if e := <-events; e.Type != TargetHealthy {
+		t.Errorf("got event %v, want the CA file of the settings trusted", e.Type)
+	}
+}
+
+func TestServiceSettingsValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings ServiceSettings
+		ok       bool
+	}{
+		{"Valid", ServiceSettings{Service: "test", Request: &RequestSettings{BearerToken: &ValueRef{File: "/token"}}}, true},
+		{"No service", ServiceSettings{Request: &RequestSettings{}}, false},
+		{"Two sources", ServiceSettings{Service: "test", Request: &RequestSettings{BearerToken: &ValueRef{Env: "TOKEN", File: "/token"}}}, false},
+		{"TLS files", ServiceSettings{Service: "test", TLS: &TLSSettings{CAFile: "/ca.crt", CertFile: "/tls.crt", KeyFile: "/tls.key"}}, true},
+		{"TLS key missing", ServiceSettings{Service: "test", TLS: &TLSSettings{CertFile: "/tls.crt"}}, false},
+		{
+			"Literal and env",
+			ServiceSettings{Service: "test", Request: &RequestSettings{BasicAuth: &BasicAuthSettings{Password: ValueRef{Value: "pass", Env: "PASS"}}}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.settings.validate(); (err == nil) != tt.ok {
+				t.Errorf("got error %v, want success %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestServiceSettingsRedacted(t *testing.T) {
+	settings := ServiceSettings{
+		Service: "test",
+		Request: &RequestSettings{
+			Headers: map[string]ValueRef{
+				"Host":         {Value: "api.example.com"},
+				"X-Health-Key": {Value: "key"},
+				"X-Api-Token":  {Env: "TOKEN"},
+			},
+			BasicAuth: &BasicAuthSettings{Username: "user", Password: ValueRef{Value: "pass"}},
+		},
+	}
+	want := &RequestSettings{
+		Headers: map[string]ValueRef{
+			"Host":         {Value: "api.example.com"},
+			"X-Health-Key": {Value: redacted},
+			"X-Api-Token":  {Env: "TOKEN"},
+		},
+		BasicAuth: &BasicAuthSettings{Username: "user", Password: ValueRef{Value: redacted}},
+	}
+	if got := settings.Redacted(); !reflect.DeepEqual(got.Request, want) {
+		t.Errorf("got %+v, want %+v", got.Request, want)
+	}
+	if settings.Request.Headers["X-Health-Key"].Value == redacted || settings.Request.BasicAuth.Password.Value == redacted {
+		t.Error("redacting changed the service settings")
+	}
+}
+
+func TestCertExpiry(t *testing.T) {
+	now := time.Now()
+	checker := &Checker{CertWarning: 30 * 24 * time.Hour, CertCritical: 7 * 24 * time.Hour}
+
+	tests := []struct {
+		name    string
+		expiry  time.Time
+		fail    bool
+		warning bool
+	}{
+		{"No TLS", time.Time{}, false, false},
+		{"Valid", now.Add(60 * 24 * time.Hour), false, false},
+		{"Warning", now.Add(10 * 24 * time.Hour), false, true},
+		{"Critical", now.Add(24 * time.Hour), true, false},
+		{"Expired", now.Add(-time.Hour), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &report{certExpiry: tt.expiry}
+			err := checker.checkCertExpiry(r, now)
+			if (err != nil) != tt.fail {
+				t.Errorf("got error %v, want failure %t", err, tt.fail)
+			}
+			if (r.warning != "") != tt.warning {
+				t.Errorf("got warning %q, want warning %t", r.warning, tt.warning)
+			}
+		})
+	}
+}
+
+func TestDegradedCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, "OK\n")
 	}))
 	defer server.Close()
 
-	checker.Add("test", server.URL)
-	<-checker.updates
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		CertWarning:      time.Until(server.Certificate().NotAfter) + time.Hour,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer checker.Stop()
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetHealthy, TargetUnhealthy))
+	defer cancel()
+
+	checker.AddTarget(TargetSpec{Name: "test", URL: server.URL, TLS: &TLSConfig{InsecureSkipVerify: true}})
+	if e := <-events; e.Type != TargetHealthy {
+		t.Fatalf("got event %+v, want the target healthy", e)
+	}
+
+	state := checker.State()
+	if !state.Cluster.Healthy {
+		t.Error("degraded service must not fail the cluster")
+	}
+	svc := state.Services[0]
+	if svc.Status != ServiceDegraded || svc.Warning == "" {
+		t.Errorf("got status %s and warning %q, want degraded", svc.Status, svc.Warning)
+	}
+	if svc.CertExpiry == nil || !svc.CertExpiry.Equal(server.Certificate().NotAfter) {
+		t.Errorf("got certificate expiry %v, want %v", svc.CertExpiry, server.Certificate().NotAfter)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"DNS", &url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "x"}}}, ErrorDNS},
+		{"Connection refused", &url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, ErrorConnectionRefused},
+		{"Timeout", &url.Error{Op: "Get", URL: "http://x", Err: context.DeadlineExceeded}, ErrorTimeout},
+		{"TLS", &url.Error{Op: "Get", URL: "https://x", Err: x509.UnknownAuthorityError{}}, ErrorTLS},
+		{"TLS alert", &url.Error{Op: "Get", URL: "https://x", Err: errors.New("remote error: tls: bad certificate")}, ErrorTLS},
+		{"HTTP 4xx", statusError(http.StatusTooManyRequests), ErrorHTTP4xx},
+		{"HTTP 5xx", statusError(http.StatusBadGateway), ErrorHTTP5xx},
+		{"Unexpected status", statusError(http.StatusNoContent), ErrorAssertion},
+		{"Other", errors.New("environment variable TOKEN is not set"), ErrorOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.err); got.Class != tt.want {
+				t.Errorf("got class %s, want %s", got.Class, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = http.Get("http://" + addr)
+	if got := classify(err).Class; got != ErrorConnectionRefused {
+		t.Errorf("got class %s for %v, want %s", got, err, ErrorConnectionRefused)
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	policy, err := ParseFailurePolicy("timeout, dns", "401,429")
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	tests := []struct {
+		err  *CheckError
+		want bool
+	}{
+		{statusError(http.StatusTooManyRequests), true},
+		{statusError(http.StatusUnauthorized), true},
+		{statusError(http.StatusNotFound), false},
+		{&CheckError{Class: ErrorTimeout, Err: context.DeadlineExceeded}, true},
+		{&CheckError{Class: ErrorTLS, Err: errors.New("tls")}, false},
+	}
+	for _, tt := range tests {
+		if got := policy.ignores(tt.err); got != tt.want {
+			t.Errorf("%s %v: got ignored %t, want %t", tt.err.Class, tt.err, got, tt.want)
+		}
+	}
+
+	var none *FailurePolicy
+	if none.ignores(statusError(http.StatusTooManyRequests)) {
+		t.Error("no policy must not ignore failures")
+	}
+
+	for _, invalid := range [][2]string{{"unknown", ""}, {"", "abc"}, {"", "600"}} {
+		if _, err := ParseFailurePolicy(invalid[0], invalid[1]); err == nil {
+			t.Errorf("want error for %q", invalid)
+		}
+	}
+}
+
+func TestAcceptedStatus(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer checker.Stop()
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetHealthy, TargetUnhealthy))
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	checker.AddTarget(TargetSpec{Name: "limited", URL: server.URL, Policy: &FailurePolicy{AcceptedStatuses: []int{429}}})
+	if e := <-events; e.Type != TargetHealthy {
+		t.Errorf("got event %+v, want the target with the accepted status healthy", e)
+	}
+
+	checker.AddTarget(TargetSpec{Name: "failed", URL: server.URL})
+	e := <-events
+	if e.Type != TargetUnhealthy || e.ErrorClass != ErrorHTTP4xx {
+		t.Errorf("got event %+v, want the target unhealthy with %s", e, ErrorHTTP4xx)
+	}
+}
+
+func TestStateChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []bool
+		want    float64
+	}{
+		{"Single", []bool{true}, 0},
+		{"Stable", []bool{true, true, true, true, true}, 0},
+		{"Alternating", []bool{true, false, true, false, true}, 100},
+		{"Oldest change", []bool{false, true, true, true, true}, 20},
+		{"Newest change", []bool{true, true, true, true, false}, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stateChange(tt.results); math.Abs(got-tt.want) > 0.001 {
+				t.Errorf("got %.3f%%, want %.3f%%", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlapping(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		FlapWindow:       4,
+		Logger:           zap.NewNop(),
+	}
+	checker.slogger = checker.Logger.Sugar()
+	checker.events = make(chan Event, eventQueueSize)
+	checker.targets = map[string]*target{"test": {name: "test"}}
+
+	clusterChanges := 0
+	check := func(ok bool) []Event {
+		r := &report{name: "test", ts: time.Now()}
+		if !ok {
+			r.err = statusError(http.StatusInternalServerError)
+		}
+		checker.update(r)
+
+		var events []Event
+		for len(checker.events) > 0 {
+			switch e := <-checker.events; {
+			case e.Target == "test":
+				events = append(events, e)
+			case e.Type == ClusterStatusChanged:
+				clusterChanges++
+			}
+		}
+		return events
+	}
+
+	for i, ok := range []bool{true, false, true, false} {
+		for _, e := range check(ok) {
+			if e.Suppressed {
+				t.Errorf("check %d: got suppressed %s before flapping", i, e.Type)
+			}
+		}
+	}
+
+	events := check(true)
+	if len(events) != 2 || events[0].Type != TargetFlapping || !events[1].Suppressed {
+		t.Fatalf("got events %+v, want flapping and a suppressed change", events)
+	}
+	if !checker.targets["test"].flapping {
+		t.Fatal("target must be flapping")
+	}
+
+	// The target counts as failed, the state before it started flapping
+	clusterChanges = 0
+	for i, ok := range []bool{false, true, false, true} {
+		for _, e := range check(ok) {
+			if !e.Suppressed {
+				t.Errorf("check %d: got %s not suppressed while flapping", i, e.Type)
+			}
+		}
+		if checker.healthy {
+			t.Errorf("check %d: got the cluster healthy while the target flaps", i)
+		}
+	}
+	if clusterChanges != 0 {
+		t.Errorf("got %d cluster status changes while flapping, want none", clusterChanges)
+	}
 
-	if !checker.Healthy() {
-		t.Error("checker must be healthy")
+	var settled []Event
+	for i := 0; i < 4; i++ {
+		settled = append(settled, check(true)...)
+	}
+	if len(settled) != 1 || settled[0].Type != TargetSettled || !settled[0].Healthy || settled[0].Suppressed {
+		t.Errorf("got events %+v, want a single settled event", settled)
+	}
+	if checker.targets["test"].flapping {
+		t.Error("target must stop flapping")
 	}
 }
 
-func TestUnhealthyEndpoint(t *testing.T) {
+func TestPacer(t *testing.T) {
+	p := &pacer{
+		interval:         time.Minute,
+		retry:            10 * time.Second,
+		failureThreshold: 3,
+		successThreshold: 2,
+	}
+
+	steps := []struct {
+		ok   bool
+		want time.Duration
+	}{
+		{true, 10 * time.Second}, // 1 of 2 successes
+		{true, time.Minute},      // healthy
+		{true, time.Minute},
+		{false, 10 * time.Second}, // 1 of 3 failures
+		{false, 10 * time.Second}, // 2 of 3 failures
+		{false, time.Minute},      // failed
+		{false, time.Minute},
+		{true, 10 * time.Second}, // recovering
+		{false, 10 * time.Second},
+		{true, 10 * time.Second},
+		{true, time.Minute}, // recovered
+	}
+	for i, step := range steps {
+		if got := p.next(step.ok); got != step.want {
+			t.Errorf("step %d: got %s, want %s", i, got, step.want)
+		}
+	}
+
+	disabled := &pacer{interval: time.Minute, failureThreshold: 3, successThreshold: 2}
+	if got := disabled.next(false); got != time.Minute {
+		t.Errorf("got %s without retry interval, want %s", got, time.Minute)
+	}
+}
+
+func TestFastRetry(t *testing.T) {
 	checker := &Checker{
 		ClusterID:        "abc",
-		Interval:         1 * time.Second,
-		FailureThreshold: 1,
+		Interval:         time.Hour,
+		RetryInterval:    10 * time.Millisecond,
+		FailureThreshold: 3,
 		SuccessThreshold: 1,
 		StateThreshold:   100,
 		Logger:           zap.NewNop(),
 	}
-	checker.updates = make(chan struct{}, 1)
 	if err := checker.Run(); err != nil {
-		t.Errorf("got error %v", err)
-		return
+		t.Fatalf("got error %v", err)
 	}
 	defer checker.Stop()
 
+	events, cancel := checker.Subscribe(TypeFilter(TargetUnhealthy))
+	defer cancel()
+
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}))
 	defer server.Close()
 
 	checker.Add("test", server.URL)
-	<-checker.updates
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Error("failure not detected with the retry interval")
+	}
+}
 
-	if checker.Healthy() {
-		t.Error("checker must be unhealthy")
+func TestDependencies(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   50,
+		Dependencies:     []Dependency{{Service: "web", DependsOn: []string{"orders"}}},
+		Logger:           zap.NewNop(),
+	}
+	checker.slogger = checker.Logger.Sugar()
+	checker.events = make(chan Event, eventQueueSize)
+	checker.dependencies = map[string][]string{"web": {"orders"}}
+	checker.targets = map[string]*target{
+		"auth":   {name: "auth"},
+		"orders": {name: "orders", dependsOn: []string{"auth"}},
+		"web":    {name: "web"},
+		"a":      {name: "a", dependsOn: []string{"b"}},
+		"b":      {name: "b", dependsOn: []string{"a"}},
+		"ok":     {name: "ok", dependsOn: []string{"auth"}},
+	}
+
+	for _, name := range []string{"ok", "a", "b", "auth", "orders", "web"} {
+		r := &report{name: name, ts: time.Now()}
+		if name != "ok" {
+			r.err = statusError(http.StatusInternalServerError)
+		}
+		checker.update(r)
+	}
+
+	events := make(map[string]Event)
+	for len(checker.events) > 0 {
+		if e := <-checker.events; e.Type == TargetUnhealthy {
+			events[e.Target] = e
+		}
+	}
+	for name, impacted := range map[string]bool{"auth": false, "orders": true, "web": true, "a": false, "b": false} {
+		if e := events[name]; e.Suppressed != impacted || (e.Status == string(ServiceImpacted)) != impacted {
+			t.Errorf("%s: got event %+v, want impacted %t", name, e, impacted)
+		}
+	}
+
+	if got, want := checker.targets["web"].impactedBy, []string{"auth"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got web impacted by %v, want %v", got, want)
+	}
+
+	// The chain auth, orders, web counts once: 1 of 4 services healthy
+	if checker.activeCount != 4 || checker.healthyCount != 1 || checker.impactedCount != 2 {
+		t.Errorf("got %d active, %d healthy, %d impacted services, want 4, 1, 2",
+			checker.activeCount, checker.healthyCount, checker.impactedCount)
+	}
+
+	checker.CountImpacted = true
+	checker.updateHealthStatus()
+	if checker.activeCount != 6 || checker.impactedCount != 2 {
+		t.Errorf("got %d active, %d impacted services, want 6, 2", checker.activeCount, checker.impactedCount)
+	}
+
+	for len(checker.events) > 0 {
+		<-checker.events
+	}
+	checker.update(&report{name: "auth", ts: time.Now()})
+	if impactedBy := checker.targets["web"].impactedBy; !reflect.DeepEqual(impactedBy, []string{"orders"}) {
+		t.Errorf("got web impacted by %v after auth recovered, want orders", impactedBy)
+	}
+
+	// orders becomes a root cause and its failure is notified, while web stays impacted
+	events = make(map[string]Event)
+	for len(checker.events) > 0 {
+		if e := <-checker.events; e.Type == TargetUnhealthy {
+			events[e.Target] = e
+		}
+	}
+	if e, ok := events["orders"]; !ok || e.Suppressed || e.Status != string(ServiceFailed) || e.ErrorClass != ErrorHTTP5xx {
+		t.Errorf("got event %+v after auth recovered, want orders failed", e)
+	}
+	if e, ok := events["web"]; ok {
+		t.Errorf("got event %+v after auth recovered, want web still impacted", e)
+	}
+}
+
+func TestImpactedRecovery(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   50,
+		Logger:           zap.NewNop(),
+	}
+	checker.slogger = checker.Logger.Sugar()
+	checker.events = make(chan Event, eventQueueSize)
+	checker.dependencies = map[string][]string{}
+	checker.targets = map[string]*target{
+		"up":   {name: "up"},
+		"down": {name: "down", dependsOn: []string{"up"}},
+	}
+
+	check := func(name string, ok bool) *Event {
+		r := &report{name: name, ts: time.Now()}
+		if !ok {
+			r.err = statusError(http.StatusInternalServerError)
+		}
+		checker.update(r)
+
+		var found *Event
+		for len(checker.events) > 0 {
+			if e := <-checker.events; e.Target == "down" {
+				found = &e
+			}
+		}
+		return found
+	}
+
+	check("up", false)
+	if e := check("down", false); e == nil || !e.Suppressed {
+		t.Fatalf("got event %+v, want the impacted failure suppressed", e)
+	}
+	if e := check("down", true); e == nil || e.Type != TargetHealthy || !e.Suppressed {
+		t.Errorf("got event %+v, want the recovery of the suppressed failure suppressed", e)
+	}
+
+	// Once notified as failed, the recovery is notified too
+	check("down", false)
+	if e := check("up", true); e == nil || e.Type != TargetUnhealthy || e.Suppressed {
+		t.Fatalf("got event %+v, want the failure notified once up recovered", e)
+	}
+	if e := check("down", true); e == nil || e.Type != TargetHealthy || e.Suppressed {
+		t.Errorf("got event %+v, want the recovery notified", e)
+	}
+}
+
+func TestSortServices(t *testing.T) {
+	services := []Service{
+		{Name: "a", Healthy: true, Status: ServiceHealthy},
+		{Name: "b", Status: ServiceImpacted},
+		{Name: "c", Healthy: true, Status: ServiceDegraded},
+		{Name: "d", Status: ServiceMissing},
+		{Name: "e", Status: ServiceFailed},
+		{Name: "f", Status: ServiceFlapping},
+		{Name: "g", Healthy: true, Status: ServiceFlapping},
+	}
+	SortServices(services)
+
+	var got []string
+	for _, svc := range services {
+		got = append(got, svc.Name)
+	}
+	if want := []string{"e", "f", "d", "b", "g", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
 	}
 }
 
@@ -141,6 +1582,57 @@ func TestClusterID(t *testing.T) {
 	}
 }
 
+func TestClusterStatus(t *testing.T) {
+	cases := []struct {
+		name        string
+		total       int
+		healthy     int
+		minTargets  int
+		emptyPolicy ClusterStatus
+		status      ClusterStatus
+	}{
+		{"EmptyHealthy", 0, 0, 0, ClusterHealthy, ClusterHealthy},
+		{"EmptyUnhealthy", 0, 0, 0, ClusterUnhealthy, ClusterUnhealthy},
+		{"EmptyUnknown", 0, 0, 0, ClusterUnknown, ClusterUnknown},
+		{"EmptyBelowMinimum", 0, 0, 1, ClusterHealthy, ClusterUnhealthy},
+		{"BelowMinimum", 2, 2, 3, ClusterHealthy, ClusterUnhealthy},
+		{"EqualToMinimum", 3, 3, 3, ClusterHealthy, ClusterHealthy},
+		{"BelowThreshold", 3, 2, 0, ClusterHealthy, ClusterUnhealthy},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			checker := &Checker{
+				StateThreshold: 100,
+				MinTargets:     c.minTargets,
+				EmptyPolicy:    c.emptyPolicy,
+				activeCount:    c.total,
+				healthyCount:   c.healthy,
+			}
+			status, reason := checker.clusterStatus()
+			if want, got := c.status, status; want != got {
+				t.Errorf("Want status %s, got %s", want, got)
+			}
+			if want, got := status != ClusterHealthy, reason != ""; want != got {
+				t.Errorf("Want reason %t, got %q", want, reason)
+			}
+		})
+	}
+}
+
+func TestEmptyPolicy(t *testing.T) {
+	checker := &Checker{
+		ClusterID:   "abc",
+		Interval:    1 * time.Second,
+		EmptyPolicy: "broken",
+		Logger:      zap.NewNop(),
+	}
+	if err := checker.Run(); err == nil {
+		checker.Stop()
+		t.Error("want error")
+	}
+}
+
 func TestHealthStatus(t *testing.T) {
 	cases := []struct {
 		name      string
@@ -165,3 +1657,505 @@ func TestHealthStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestSyntheticCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/login":
+			w.Header().Set("X-Session", "s1")
+			io.WriteString(w, `{"user": {"id": 42, "roles": ["admin"]}}`)
+		case r.URL.Path == "/users/42" && r.Header.Get("X-Session") == "s1":
+			io.WriteString(w, `{"name": "alice"}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer checker.Stop()
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetHealthy, TargetUnhealthy))
+	defer cancel()
+
+	login := Step{
+		Name:    "login",
+		Method:  http.MethodPost,
+		URL:     "/login",
+		Body:    `{"user": "alice"}`,
+		Extract: []Extraction{{Var: "id", JSONPath: "$.user.id"}, {Var: "session", Header: "X-Session"}},
+		Assert:  []Assertion{{JSONPath: "$.user.roles[0]", Equals: "admin"}},
+	}
+	check := SyntheticCheck{Name: "ok", URL: server.URL, Steps: []Step{
+		login,
+		{
+			Name:    "profile",
+			URL:     "/users/${id}",
+			Headers: map[string]string{"X-Session": "${session}"},
+			Assert:  []Assertion{{JSONPath: "name", Equals: "alice"}, {Contains: "ali"}},
+		},
+	}}
+	spec, err := check.Target()
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	checker.AddTarget(spec)
+	if e := <-events; e.Type != TargetHealthy {
+		t.Fatalf("got event %+v, want the target healthy", e)
+	}
+	steps := checker.State().Services[0].Steps
+	if len(steps) != 2 || steps[0].Name != "login" || steps[1].Status != http.StatusOK || steps[1].Error != "" {
+		t.Errorf("got steps %+v, want both steps passed", steps)
+	}
+
+	checker.AddTarget(TargetSpec{Name: "failed", URL: server.URL, Steps: []Step{
+		login,
+		{Name: "profile", URL: "/users/${id}", Assert: []Assertion{{JSONPath: "name", Equals: "alice"}}},
+	}})
+	e := <-events
+	if e.Type != TargetUnhealthy || e.ErrorClass != ErrorHTTP4xx || !strings.HasPrefix(e.Error, "step profile:") {
+		t.Errorf("got event %+v, want the profile step failed with %s", e, ErrorHTTP4xx)
+	}
+
+	checker.AddTarget(TargetSpec{Name: "assertion", URL: server.URL, Steps: []Step{
+		{Method: http.MethodPost, URL: "/login", Assert: []Assertion{{Header: "X-Session", Equals: "s2"}}},
+	}})
+	e = <-events
+	if e.Type != TargetUnhealthy || e.ErrorClass != ErrorAssertion {
+		t.Errorf("got event %+v, want the step failed with %s", e, ErrorAssertion)
+	}
+}
+
+func TestSyntheticCheckOtherHost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "TOP-SECRET auth="+r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer checker.Stop()
+
+	events, cancel := checker.Subscribe(TypeFilter(TargetHealthy, TargetUnhealthy))
+	defer cancel()
+
+	request := &RequestConfig{BearerToken: Literal("tok")}
+	checker.AddTarget(TargetSpec{Name: "credentials", URL: server.URL, Request: request, Steps: []Step{
+		{URL: "/"},
+		{URL: other.URL, Assert: []Assertion{{Equals: "TOP-SECRET auth="}}},
+	}})
+	if e := <-events; e.Type != TargetHealthy {
+		t.Errorf("got event %+v, want the credentials not sent to the other host", e)
+	}
+
+	checker.AddTarget(TargetSpec{Name: "assertion", URL: server.URL, Steps: []Step{
+		{URL: other.URL, Assert: []Assertion{{Equals: "nope"}}},
+	}})
+	if e := <-events; e.Type != TargetUnhealthy || strings.Contains(e.Error, "TOP-SECRET") {
+		t.Errorf("got event %+v, want the assertion failed without the response body", e)
+	}
+
+	checker.AddTarget(TargetSpec{Name: "local", URL: server.URL, StepsOnTargetHost: true, Steps: []Step{
+		{URL: other.URL},
+	}})
+	if e := <-events; e.Type != TargetUnhealthy || e.ErrorClass != ErrorOther {
+		t.Errorf("got event %+v, want the step to the other host failed with %s", e, ErrorOther)
+	}
+}
+
+func TestSyntheticCheckRedacted(t *testing.T) {
+	check := SyntheticCheck{
+		Name: "checkout.shop",
+		Steps: []Step{
+			{URL: "http://checkout.shop/login", Body: `{"password": "s3cret"}`},
+			{URL: "/cart", Headers: map[string]string{"Authorization": "Bearer ${token}", "X-Tenant": "shop"}},
+		},
+	}
+
+	got := check.Redacted()
+	if got.Steps[0].Body != redacted || got.Steps[1].Headers["Authorization"] != redacted || got.Steps[1].Headers["X-Tenant"] != "shop" {
+		t.Errorf("got steps %+v, want the body and the Authorization header redacted", got.Steps)
+	}
+	if check.Steps[0].Body == redacted || check.Steps[1].Headers["Authorization"] == redacted {
+		t.Error("redacting changed the synthetic check")
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  string
+		steps []Step
+		ok    bool
+	}{
+		{"Base URL", "http://example.com", []Step{{URL: "/healthz"}}, true},
+		{"Absolute URL", "", []Step{{URL: "http://example.com/healthz"}}, true},
+		{"No steps", "http://example.com", nil, false},
+		{"Relative URL", "", []Step{{URL: "/healthz"}}, false},
+		{
+			"Variables",
+			"http://example.com",
+			[]Step{{Extract: []Extraction{{Var: "id", JSONPath: "id"}}}, {URL: "/items/${id}"}},
+			true,
+		},
+		{"Undefined variable", "http://example.com", []Step{{URL: "/items/${id}"}}, false},
+		{
+			"Variable of the same step",
+			"http://example.com",
+			[]Step{{Body: "${id}", Extract: []Extraction{{Var: "id", JSONPath: "id"}}}},
+			false,
+		},
+		{"Extraction without source", "http://example.com", []Step{{Extract: []Extraction{{Var: "id"}}}}, false},
+		{"Invalid JSON path", "http://example.com", []Step{{Assert: []Assertion{{JSONPath: "$.items[x]"}}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSteps(tt.base, tt.steps); (err == nil) != tt.ok {
+				t.Errorf("got error %v, want success %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLookupJSON(t *testing.T) {
+	var doc interface{}
+	decoder := json.NewDecoder(strings.NewReader(`{"items": [{"id": 1.50, "ok": true}], "name": "a"}`))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	tests := []struct {
+		path  string
+		want  string
+		found bool
+	}{
+		{"$.name", "a", true},
+		{"name", "a", true},
+		{"$.items[0].id", "1.50", true},
+		{"items[0].ok", "true", true},
+		{"$.items[0]", `{"id":1.50,"ok":true}`, true},
+		{"$.items[1].id", "", false},
+		{"$.name.first", "", false},
+		{"$.missing", "", false},
+	}
+	for _, tt := range tests {
+		got, found, err := lookupJSON(doc, tt.path)
+		if err != nil || got != tt.want || found != tt.found {
+			t.Errorf("%s: got %q, %t, %v, want %q, %t", tt.path, got, found, err, tt.want, tt.found)
+		}
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	healthPath := "/health"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case healthPath:
+		case "/healthz":
+			// Failing the checks, so it must not be discovered
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	checker := &Checker{Logger: zap.NewNop()}
+	checker.slogger = checker.Logger.Sugar()
+	target := &target{
+		name:       "test",
+		candidates: []string{server.URL + "/healthz", server.URL + "/health", server.URL + "/ready"},
+	}
+	client := &targetClient{}
+	request := &targetRequest{}
+
+	r := checker.check(client, request, target, time.Now(), time.Second)
+	if r.err != nil || r.discovered != server.URL+"/health" {
+		t.Fatalf("got error %v and URL %q, want %s/health discovered", r.err, r.discovered, server.URL)
+	}
+
+	healthPath = "/ready"
+	r = checker.check(client, request, target, time.Now(), time.Second)
+	if r.err != nil || r.discovered != server.URL+"/ready" {
+		t.Fatalf("got error %v and URL %q, want %s/ready discovered again", r.err, r.discovered, server.URL)
+	}
+
+	healthPath = "/missing"
+	r = checker.check(client, request, target, time.Now(), time.Second)
+	if r.err == nil || r.err.Class != ErrorHTTP4xx || r.discovered != "" {
+		t.Errorf("got error %v and URL %q, want no health URL found", r.err, r.discovered)
+	}
+	if request.url != "" {
+		t.Errorf("got health URL %q, want none", request.url)
+	}
+
+	target.policy = &FailurePolicy{AcceptedStatuses: []int{http.StatusNoContent}}
+	r = checker.check(client, request, target, time.Now(), time.Second)
+	if r.err != nil || r.discovered != server.URL+"/healthz" {
+		t.Errorf("got error %v and URL %q, want %s/healthz accepted by the policy", r.err, r.discovered, server.URL)
+	}
+}
+
+func TestHealthFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		body        string
+		class       ErrorClass
+		warning     string
+		components  []Component
+	}{
+		{"Plain text", "text/plain", http.StatusOK, "DOWN", "", "", nil},
+		{
+			"Health JSON pass",
+			"application/health+json",
+			http.StatusOK,
+			`{"status": "pass", "checks": {"db:connections": [{"status": "pass"}]}}`,
+			"",
+			"",
+			[]Component{{Name: "db:connections", Status: "pass"}},
+		},
+		{
+			"Health JSON warn",
+			"application/health+json",
+			http.StatusOK,
+			`{"status": "warn", "checks": {"cpu:utilization": [{"componentId": "a", "status": "pass"}, {"componentId": "b", "status": "warn", "output": "busy"}]}}`,
+			"",
+			"health status warn: cpu:utilization/b",
+			[]Component{{Name: "cpu:utilization/a", Status: "pass"}, {Name: "cpu:utilization/b", Status: "warn", Output: "busy"}},
+		},
+		{
+			"Health JSON fail",
+			"application/health+json; charset=utf-8",
+			http.StatusOK,
+			`{"status": "fail", "output": "disk full"}`,
+			ErrorAssertion,
+			"",
+			nil,
+		},
+		{
+			"Spring Boot down",
+			"application/vnd.spring-boot.actuator.v3+json",
+			http.StatusServiceUnavailable,
+			`{"status": "DOWN", "components": {"db": {"status": "DOWN", "components": {"primary": {"status": "DOWN", "details": {"error": "timeout"}}}}, "ping": {"status": "UP"}}}`,
+			ErrorHTTP5xx,
+			"",
+			[]Component{
+				{Name: "db", Status: "DOWN"},
+				{Name: "db/primary", Status: "DOWN", Output: "timeout"},
+				{Name: "ping", Status: "UP"},
+			},
+		},
+		{
+			"MicroProfile up",
+			"application/json",
+			http.StatusOK,
+			`{"status": "UP", "checks": [{"name": "live", "status": "UP", "data": {"uptime": 10}}]}`,
+			"",
+			"",
+			[]Component{{Name: "live", Status: "UP"}},
+		},
+		{"Other JSON", "application/json", http.StatusOK, `{"version": "1.0"}`, "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			checker := &Checker{Logger: zap.NewNop()}
+			checker.slogger = checker.Logger.Sugar()
+			r := checker.check(&targetClient{}, &targetRequest{url: server.URL}, &target{name: "test"}, time.Now(), time.Second)
+
+			var class ErrorClass
+			if r.err != nil {
+				class = r.err.Class
+			}
+			if class != tt.class || r.warning != tt.warning {
+				t.Errorf("got error %v and warning %q, want %q and %q", r.err, r.warning, tt.class, tt.warning)
+			}
+			if !reflect.DeepEqual(r.components, tt.components) {
+				t.Errorf("got components %+v, want %+v", r.components, tt.components)
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer checker.Stop()
+
+	done := make(chan struct{})
+	checker.accessors <- func(c *Checker) {
+		for _, t := range []*target{
+			{name: "auth.platform", namespace: "platform", labels: map[string]string{"app": "auth"}, state: -1},
+			{name: "api.shop", namespace: "shop", labels: map[string]string{"app": "api"}, healthy: true, state: 1},
+			{name: "web.shop", namespace: "shop", labels: map[string]string{"app": "web"}, healthy: true, state: 1},
+			{name: "db.shop", namespace: "shop", labels: map[string]string{"app": "db"}, state: -1},
+			{name: "new.shop", namespace: "shop"},
+		} {
+			t.done = make(chan struct{})
+			t.lastReport = &report{name: t.name}
+			c.targets[t.name] = t
+		}
+		c.missing = map[string]bool{"cart.shop": true}
+		close(done)
+	}
+	<-done
+
+	names := func(cs ClusterState) []string {
+		var names []string
+		for _, svc := range cs.Services {
+			names = append(names, svc.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name  string
+		query ServiceQuery
+		want  []string
+		ok    bool
+	}{
+		{"All", ServiceQuery{}, []string{"auth.platform", "db.shop", "cart.shop", "api.shop", "web.shop"}, true},
+		{"Namespace", ServiceQuery{Namespace: "shop", Sort: SortByName}, []string{"api.shop", "db.shop", "web.shop"}, true},
+		{"Statuses", ServiceQuery{Statuses: []ServiceStatus{ServiceFailed, ServiceMissing}}, []string{"auth.platform", "db.shop", "cart.shop"}, true},
+		{"Selector", ServiceQuery{Selector: "app in (auth,web)"}, []string{"auth.platform", "web.shop"}, true},
+		{"Prefix", ServiceQuery{Prefix: "a", Sort: SortByName}, []string{"api.shop", "auth.platform"}, true},
+		{"Invalid selector", ServiceQuery{Selector: "app in"}, nil, false},
+		{"Invalid sort", ServiceQuery{Sort: "age"}, nil, false},
+		{"Invalid continue", ServiceQuery{Continue: "abc"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs, err := checker.Query(tt.query)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got error %v, want success %t", err, tt.ok)
+			}
+			if got := names(cs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, sort := range []ServiceSort{SortByStatus, SortByName} {
+		all, _ := checker.Query(ServiceQuery{Sort: sort})
+		var paged []string
+		query := ServiceQuery{Sort: sort, Limit: 2}
+		for pages := 0; ; pages++ {
+			cs, err := checker.Query(query)
+			if err != nil || pages > 3 {
+				t.Fatalf("%s: got error %v after %d pages", sort, err, pages)
+			}
+			paged = append(paged, names(cs)...)
+			if cs.Continue == "" {
+				break
+			}
+			query.Continue = cs.Continue
+		}
+		if !reflect.DeepEqual(paged, names(all)) {
+			t.Errorf("%s: got pages %v, want %v", sort, paged, names(all))
+		}
+	}
+
+	cs, _ := checker.Query(ServiceQuery{Limit: 2})
+	if _, err := checker.Query(ServiceQuery{Sort: SortByName, Continue: cs.Continue}); err == nil {
+		t.Error("want error for the continue token of another sort order")
+	}
+	if len(cs.Namespaces) != 0 {
+		t.Errorf("got namespaces %+v, want none without the summary", cs.Namespaces)
+	}
+	if cs, _ = checker.Query(ServiceQuery{Limit: 2, Namespaces: true}); len(cs.Namespaces) != 2 {
+		t.Errorf("got namespaces %+v, want platform and shop", cs.Namespaces)
+	}
+}
+
+func TestNamespaceStatus(t *testing.T) {
+	checker := &Checker{
+		ClusterID:        "abc",
+		Interval:         1 * time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		StateThreshold:   100,
+		Logger:           zap.NewNop(),
+	}
+	if err := checker.Run(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer checker.Stop()
+
+	done := make(chan struct{})
+	checker.accessors <- func(c *Checker) {
+		for _, t := range []*target{
+			{name: "auth.platform", namespace: "platform", healthy: true, state: 1},
+			{name: "api.shop", namespace: "shop", healthy: true, state: 1},
+			{name: "web.shop", namespace: "shop", healthy: true, state: 1},
+			{name: "db.shop", namespace: "shop", state: -1},
+			{name: "cart.shop", namespace: "shop", state: -1, muted: true},
+		} {
+			t.done = make(chan struct{})
+			t.lastReport = &report{name: t.name}
+			c.targets[t.name] = t
+		}
+		close(done)
+	}
+	<-done
+
+	if ns, ok := checker.NamespaceStatus("shop"); !ok || ns.Healthy || ns.Total != 3 || ns.Failed != 1 || ns.Muted != 1 || ns.Threshold != 100 {
+		t.Errorf("got %+v, want shop unhealthy with 1 of 3 services failed", ns)
+	}
+	if err := checker.SetNamespaceThreshold("shop", 60); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if ns, _ := checker.NamespaceStatus("shop"); !ns.Healthy || ns.Threshold != 60 {
+		t.Errorf("got %+v, want shop healthy with its threshold", ns)
+	}
+	if _, ok := checker.NamespaceStatus("missing"); ok {
+		t.Error("want no status of the namespace without services")
+	}
+	if err := checker.SetNamespaceThreshold("shop", 101); err == nil {
+		t.Error("want error for the invalid threshold")
+	}
+
+	checker.SetNamespaceThreshold("shop", 0)
+	namespaces := checker.State().Namespaces
+	if len(namespaces) != 2 || namespaces[0].Name != "platform" || !namespaces[0].Healthy || namespaces[1].Healthy {
+		t.Errorf("got namespaces %+v, want platform healthy and shop unhealthy", namespaces)
+	}
+}
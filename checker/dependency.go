@@ -0,0 +1,142 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Dependency declares the upstream services a service depends on.
+// A failed service with a failed upstream is impacted by the failure
+// of the upstream instead of failing on its own.
+type Dependency struct {
+	Service   string   `json:"service"`   // Dependent service name
+	DependsOn []string `json:"dependsOn"` // Upstream service names
+}
+
+// validate checks the dependency definition
+func (d *Dependency) validate() error {
+	if d.Service == "" {
+		return fmt.Errorf("dependency must define the service")
+	}
+	if len(d.DependsOn) == 0 {
+		return fmt.Errorf("dependency of %s must define the upstream services", d.Service)
+	}
+	return nil
+}
+
+// failed reports whether the target is checked and counted as unhealthy
+func (t *target) failed() bool {
+	return t.state != 0 && !t.countedHealthy()
+}
+
+// failedUpstreams returns the failed upstream targets of the target,
+// declared by the target itself or by the configured dependencies
+func (c *Checker) failedUpstreams(t *target) []*target {
+	var upstreams []*target
+	for _, names := range [][]string{t.dependsOn, c.dependencies[t.name]} {
+		for _, name := range names {
+			if u, ok := c.targets[name]; ok && u != t && u.failed() {
+				upstreams = append(upstreams, u)
+			}
+		}
+	}
+	return upstreams
+}
+
+// updateImpacts finds the root causes of the failed targets.
+// The failure of a target only notified as impacted is notified
+// once it is no longer impacted, e.g. when its upstream recovers.
+func (c *Checker) updateImpacts() {
+	for _, t := range c.targets {
+		impacted := len(t.impactedBy) > 0
+		t.impactedBy = c.rootCauses(t)
+		if impacted && len(t.impactedBy) == 0 && t.failed() {
+			e := Event{
+				Type:       TargetUnhealthy,
+				Target:     t.name,
+				Namespace:  t.namespace,
+				Status:     string(ServiceFailed),
+				Suppressed: t.muted || t.flapping,
+			}
+			if t.lastReport != nil && t.lastReport.err != nil {
+				e.Error = t.lastReport.err.Error()
+				e.ErrorClass = t.lastReport.err.Class
+			}
+			c.publish(e)
+			t.hidden = false
+		}
+	}
+}
+
+// rootCauses returns the sorted names of the root causes impacting the target.
+// A root cause is a failed target without failed upstreams. A failed target
+// is impacted by the root causes reachable through its failed upstreams;
+// the failed targets depending on each other without a root cause are not impacted.
+func (c *Checker) rootCauses(t *target) []string {
+	if !t.failed() || len(c.failedUpstreams(t)) == 0 {
+		return nil
+	}
+
+	roots := make(map[string]bool)
+	visited := map[*target]bool{t: true}
+	queue := c.failedUpstreams(t)
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		if visited[u] {
+			continue
+		}
+		visited[u] = true
+
+		upstreams := c.failedUpstreams(u)
+		if len(upstreams) == 0 {
+			roots[u.name] = true
+		}
+		queue = append(queue, upstreams...)
+	}
+
+	var names []string
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serviceRank orders the services by the attention they need
+func serviceRank(svc *Service) int {
+	return statusRank(svc.Status, svc.Healthy)
+}
+
+// statusRank orders the service statuses by the attention they need
+func statusRank(status ServiceStatus, healthy bool) int {
+	switch status {
+	case ServiceFailed:
+		return 0
+	case ServiceMissing:
+		return 1
+	case ServiceImpacted:
+		return 2
+	case ServiceFlapping:
+		if !healthy {
+			return 0
+		}
+		return 3
+	case ServiceDegraded:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// SortServices sorts the services with the root causes of the failures first,
+// then the missing, the impacted, the flapping, the degraded and the healthy services
+func SortServices(services []Service) {
+	sort.SliceStable(services, func(i, j int) bool {
+		ri, rj := serviceRank(&services[i]), serviceRank(&services[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return services[i].Name < services[j].Name
+	})
+}
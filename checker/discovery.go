@@ -0,0 +1,59 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// discoverOrProbe checks the target at its health URL. A target with candidate URLs
+// discovers its health URL first, and again once the discovered one answers 404.
+func (c *Checker) discoverOrProbe(ctx context.Context, client *targetClient, request *targetRequest, t *target, r *report) (*http.Response, error) {
+	if len(t.candidates) == 0 || len(t.steps) > 0 {
+		return c.probe(ctx, client, request, t, r)
+	}
+	if request.url == "" {
+		return c.discover(ctx, client, request, t, r)
+	}
+
+	resp, err := c.probe(ctx, client, request, t, r)
+	r.discovered = request.url
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		return resp, err
+	}
+	resp.Body.Close()
+	c.slogger.Warnf("Health URL %s of target %s is not found, discovering it again", request.url, t.name)
+	return c.discover(ctx, client, request, t, r)
+}
+
+// discover tries the candidate URLs of the target in order and keeps the first one
+// passing the status check, or accepted by the failure policy of the target, as its
+// health URL. The response of the chosen URL is the check result.
+func (c *Checker) discover(ctx context.Context, client *targetClient, request *targetRequest, t *target, r *report) (*http.Response, error) {
+	var lastErr error
+	for _, candidate := range t.candidates {
+		request.url = candidate
+		resp, err := c.probe(ctx, client, request, t, r)
+		if err == nil {
+			if err = checkStatus(resp.StatusCode); err == nil || t.policy.ignores(classify(err)) {
+				c.slogger.Infof("Discovered health URL %s of target %s", candidate, t.name)
+				r.discovered = candidate
+				return resp, nil
+			}
+			resp.Body.Close()
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	request.url = ""
+	r.discovered = ""
+	checkErr := classify(lastErr)
+	return nil, &CheckError{
+		Class:      checkErr.Class,
+		StatusCode: checkErr.StatusCode,
+		Err:        fmt.Errorf("no health URL found, last candidate failed: %v", checkErr.Err),
+	}
+}
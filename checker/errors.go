@@ -0,0 +1,158 @@
+package checker
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrorClass is the category of a check failure
+type ErrorClass string
+
+// Error classes
+const (
+	ErrorDNS               ErrorClass = "dns"
+	ErrorConnectionRefused ErrorClass = "connection-refused"
+	ErrorTimeout           ErrorClass = "timeout"
+	ErrorTLS               ErrorClass = "tls"
+	ErrorHTTP4xx           ErrorClass = "http-4xx"
+	ErrorHTTP5xx           ErrorClass = "http-5xx"
+	ErrorAssertion         ErrorClass = "assertion" // the response doesn't meet the expectations
+	ErrorOther             ErrorClass = "other"
+)
+
+// errorClasses lists the known error classes
+var errorClasses = []ErrorClass{
+	ErrorDNS, ErrorConnectionRefused, ErrorTimeout, ErrorTLS,
+	ErrorHTTP4xx, ErrorHTTP5xx, ErrorAssertion, ErrorOther,
+}
+
+// ParseErrorClass parses the name of an error class
+func ParseErrorClass(s string) (ErrorClass, error) {
+	for _, class := range errorClasses {
+		if string(class) == s {
+			return class, nil
+		}
+	}
+	return "", fmt.Errorf("unknown error class %q", s)
+}
+
+// CheckError is a classified check failure
+type CheckError struct {
+	Class      ErrorClass
+	StatusCode int // Response status code of the HTTP errors
+	Err        error
+}
+
+func (e *CheckError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CheckError) Unwrap() error {
+	return e.Err
+}
+
+// statusError classifies the unexpected response status
+func statusError(code int) *CheckError {
+	class := ErrorAssertion
+	switch {
+	case code >= 400 && code < 500:
+		class = ErrorHTTP4xx
+	case code >= 500 && code < 600:
+		class = ErrorHTTP5xx
+	}
+	return &CheckError{Class: class, StatusCode: code, Err: fmt.Errorf("Status %d", code)}
+}
+
+// classify wraps the error of the check into a classified one
+func classify(err error) *CheckError {
+	var checkErr *CheckError
+	if errors.As(err, &checkErr) {
+		return checkErr
+	}
+	return &CheckError{Class: errorClass(err), Err: err}
+}
+
+// errorClass finds the class of the network and TLS errors of the HTTP client
+func errorClass(err error) ErrorClass {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorConnectionRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &unknownAuthority), errors.As(err, &hostname), errors.As(err, &invalid),
+		strings.Contains(err.Error(), "tls: "), strings.Contains(err.Error(), "x509: "):
+		return ErrorTLS
+	default:
+		return ErrorOther
+	}
+}
+
+// FailurePolicy decides which check failures of a target don't count as failures,
+// e.g. to consider a rate limited or unauthorized service up
+type FailurePolicy struct {
+	IgnoredClasses   []ErrorClass // Error classes that don't count as failures
+	AcceptedStatuses []int        // Response status codes that don't count as failures
+}
+
+// ParseFailurePolicy parses the comma separated lists of the ignored error classes
+// and the accepted status codes
+func ParseFailurePolicy(classes, statuses string) (*FailurePolicy, error) {
+	var policy FailurePolicy
+	for _, s := range strings.Split(classes, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		class, err := ParseErrorClass(s)
+		if err != nil {
+			return nil, err
+		}
+		policy.IgnoredClasses = append(policy.IgnoredClasses, class)
+	}
+
+	for _, s := range strings.Split(statuses, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status code %q", s)
+		}
+		policy.AcceptedStatuses = append(policy.AcceptedStatuses, code)
+	}
+	return &policy, nil
+}
+
+// ignores reports whether the failure doesn't count as a failure of the target
+func (p *FailurePolicy) ignores(err *CheckError) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, class := range p.IgnoredClasses {
+		if class == err.Class {
+			return true
+		}
+	}
+	if err.StatusCode != 0 {
+		for _, code := range p.AcceptedStatuses {
+			if code == err.StatusCode {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package checker
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultEventBuffer  = 64
+	defaultEventHistory = 256
+	eventQueueSize      = 1024
+)
+
+// EventType identifies the kind of change an event reports
+type EventType string
+
+// Event types published by the checker
+const (
+	TargetAdded          EventType = "target-added"
+	TargetRemoved        EventType = "target-removed"
+	TargetHealthy        EventType = "target-healthy"
+	TargetUnhealthy      EventType = "target-unhealthy"
+	TargetFlapping       EventType = "target-flapping"
+	TargetSettled        EventType = "target-settled" // the target stopped flapping
+	ClusterStatusChanged EventType = "cluster-status-changed"
+)
+
+// Event describes a change of a target or of the cluster health state
+type Event struct {
+	ID         uint64     `json:"id"`                   // Sequence number, increasing by one per published event
+	Type       EventType  `json:"type"`                 // Kind of the change
+	Time       time.Time  `json:"time"`                 // Time of the change
+	Target     string     `json:"target,omitempty"`     // Target name, empty for cluster events
+	Namespace  string     `json:"namespace,omitempty"`  // Namespace of the target, if any
+	Healthy    bool       `json:"healthy"`              // Health state after the change
+	Status     string     `json:"status,omitempty"`     // Detailed status after the change, if any
+	Error      string     `json:"error,omitempty"`      // Last check error of the target or reason of the cluster status
+	ErrorClass ErrorClass `json:"errorClass,omitempty"` // Class of the last check error of the target
+
+	// Suppressed is set for the changes that must not be notified,
+	// e.g. transitions of silenced targets
+	Suppressed bool `json:"suppressed,omitempty"`
+}
+
+// EventFilter selects the events delivered to a subscriber.
+// It is called from the event dispatcher and must not block.
+// A nil filter accepts all events.
+type EventFilter func(e Event) bool
+
+// TypeFilter returns a filter accepting only events of the given types
+func TypeFilter(types ...EventType) EventFilter {
+	return func(e Event) bool {
+		for _, t := range types {
+			if e.Type == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NamespaceFilter returns a filter accepting only events of targets in the given namespaces
+func NamespaceFilter(namespaces ...string) EventFilter {
+	return func(e Event) bool {
+		for _, n := range namespaces {
+			if e.Namespace == n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// subscriber is a single consumer of checker events
+type subscriber struct {
+	filter  EventFilter
+	events  chan Event
+	dropped int
+
+	resume bool   // whether to replay the history on subscription
+	lastID uint64 // last event seen by the subscriber, replay starts after it
+}
+
+// deliver sends the event to the subscriber without blocking.
+// If the subscriber buffer is full, the oldest buffered event is dropped
+// so the subscriber always catches up with the most recent changes.
+func (s *subscriber) deliver(e Event) {
+	if s.filter != nil && !s.filter(e) {
+		return
+	}
+	for {
+		select {
+		case s.events <- e:
+			return
+		default:
+		}
+		select {
+		case <-s.events:
+			s.dropped++
+		default:
+		}
+	}
+}
+
+// Subscribe registers a new subscriber for the events matching the filter.
+// Each subscriber gets its own buffer of EventBuffer events; when a slow
+// consumer lets the buffer fill up, the oldest events are dropped.
+// Gaps can be detected by the event IDs.
+// The returned function cancels the subscription and closes the channel.
+func (c *Checker) Subscribe(filter EventFilter) (<-chan Event, func()) {
+	return c.subscribe(&subscriber{
+		filter: filter,
+		events: make(chan Event, c.eventBuffer()),
+	})
+}
+
+// SubscribeFrom is like Subscribe, but first replays the recent events
+// published after the event with the given ID. Only the last EventHistory
+// events are kept for replay, older events are lost.
+func (c *Checker) SubscribeFrom(lastID uint64, filter EventFilter) (<-chan Event, func()) {
+	return c.subscribe(&subscriber{
+		filter: filter,
+		events: make(chan Event, c.eventBuffer()+c.eventHistory()),
+		resume: true,
+		lastID: lastID,
+	})
+}
+
+func (c *Checker) subscribe(s *subscriber) (<-chan Event, func()) {
+	select {
+	case c.subscribing <- s:
+	case <-c.done:
+		close(s.events)
+		return s.events, func() {}
+	}
+
+	var once sync.Once
+	return s.events, func() {
+		once.Do(func() {
+			select {
+			case c.unsubscribing <- s:
+			case <-c.done:
+			}
+		})
+	}
+}
+
+func (c *Checker) eventBuffer() int {
+	if c.EventBuffer <= 0 {
+		return defaultEventBuffer
+	}
+	return c.EventBuffer
+}
+
+func (c *Checker) eventHistory() int {
+	if c.EventHistory <= 0 {
+		return defaultEventHistory
+	}
+	return c.EventHistory
+}
+
+// publish queues the event for delivery to the subscribers.
+// It must be called from the run loop only.
+func (c *Checker) publish(e Event) {
+	c.lastEventID++
+	e.ID = c.lastEventID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	select {
+	case c.events <- e:
+	default:
+		c.slogger.Warnf("Event queue is full, dropping event %d (%s)", e.ID, e.Type)
+	}
+}
+
+// dispatch delivers the published events to the subscribers
+// and keeps the most recent ones for replay
+func (c *Checker) dispatch() {
+	subscribers := make(map[*subscriber]struct{})
+	history := make([]Event, 0, c.eventHistory())
+	for {
+		select {
+		case e := <-c.events:
+			if len(history) == cap(history) {
+				copy(history, history[1:])
+				history = history[:len(history)-1]
+			}
+			history = append(history, e)
+			for s := range subscribers {
+				s.deliver(e)
+			}
+		case s := <-c.subscribing:
+			if s.resume {
+				for _, e := range history {
+					if e.ID > s.lastID {
+						s.deliver(e)
+					}
+				}
+			}
+			subscribers[s] = struct{}{}
+		case s := <-c.unsubscribing:
+			if _, ok := subscribers[s]; ok {
+				delete(subscribers, s)
+				close(s.events)
+				if s.dropped > 0 {
+					c.slogger.Debugf("Subscriber dropped %d events", s.dropped)
+				}
+			}
+		case <-c.done:
+			for s := range subscribers {
+				close(s.events)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Expectation declares a service that must exist in the cluster.
+// It is satisfied by a target matching all its non-empty criteria:
+// the exact target name, the namespace and the label selector.
+// An unsatisfied expectation counts as a failed service with the missing status.
+type Expectation struct {
+	Name      string `json:"name,omitempty"`      // Exact target name
+	Namespace string `json:"namespace,omitempty"` // Target namespace
+	Selector  string `json:"selector,omitempty"`  // Target label selector, e.g. "app=auth"
+
+	selector labels.Selector
+}
+
+// String identifies the expected service in the reports
+func (e *Expectation) String() string {
+	if e.Name != "" {
+		return e.Name
+	}
+
+	var criteria []string
+	if e.Namespace != "" {
+		criteria = append(criteria, "namespace="+e.Namespace)
+	}
+	if e.Selector != "" {
+		criteria = append(criteria, "selector="+e.Selector)
+	}
+	return strings.Join(criteria, ",")
+}
+
+// validate checks the expectation definition
+func (e *Expectation) validate() error {
+	if e.Name == "" && e.Namespace == "" && e.Selector == "" {
+		return fmt.Errorf("expected service must define a name, a namespace or a selector")
+	}
+
+	selector, err := labels.Parse(e.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid expected service selector: %v", err)
+	}
+	e.selector = selector
+	return nil
+}
+
+// matches reports whether the target satisfies the expectation
+func (e *Expectation) matches(t *target) bool {
+	return matchTarget(t, e.Name, e.Namespace, e.selector)
+}
+
+// matchTarget reports whether the target matches all the non-empty criteria
+func matchTarget(t *target, name, namespace string, selector labels.Selector) bool {
+	if name != "" && name != t.name {
+		return false
+	}
+	if namespace != "" && namespace != t.namespace {
+		return false
+	}
+	return selector == nil || selector.Matches(labels.Set(t.labels))
+}
+
+// SetExpected replaces the expected services declared by the given source,
+// e.g. the config file or a ConfigMap
+func (c *Checker) SetExpected(source string, expected []Expectation) error {
+	for i := range expected {
+		if err := expected[i].validate(); err != nil {
+			return fmt.Errorf("invalid expected service %d: %v", i, err)
+		}
+	}
+
+	done := make(chan struct{})
+	c.accessors <- func(c *Checker) {
+		c.expected[source] = expected
+		c.slogger.Infof("Expecting %d services from %s", len(expected), source)
+		c.updateHealthStatus()
+		close(done)
+	}
+	<-done
+	return nil
+}
+
+// updateMissing evaluates the expected services without a matching target.
+// Nothing is missing before the discovery sources complete their initial sync.
+func (c *Checker) updateMissing() {
+	missing := make(map[string]bool)
+	if c.synced() {
+		for _, expected := range c.expected {
+			for i := range expected {
+				if !c.satisfied(&expected[i]) {
+					missing[expected[i].String()] = true
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		if !c.missing[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.slogger.Warnf("Expected service %s is missing", name)
+		c.publish(Event{Type: TargetUnhealthy, Target: name, Status: string(ServiceMissing), Error: "service is missing"})
+	}
+	c.missing = missing
+}
+
+func (c *Checker) satisfied(e *Expectation) bool {
+	if e.Name != "" {
+		t, ok := c.targets[e.Name]
+		return ok && e.matches(t)
+	}
+	for _, t := range c.targets {
+		if e.matches(t) {
+			return true
+		}
+	}
+	return false
+}
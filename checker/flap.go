@@ -0,0 +1,111 @@
+package checker
+
+// Default flap detection thresholds, in percent of state changes
+const (
+	defaultFlapHighThreshold = 50
+	defaultFlapLowThreshold  = 25
+)
+
+// flapThresholds returns the configured thresholds or the defaults
+func (c *Checker) flapThresholds() (high, low float64) {
+	high, low = defaultFlapHighThreshold, defaultFlapLowThreshold
+	if c.FlapHighThreshold > 0 {
+		high = float64(c.FlapHighThreshold)
+	}
+	if c.FlapLowThreshold > 0 {
+		low = float64(c.FlapLowThreshold)
+	}
+	return high, low
+}
+
+// stateChange computes the weighted percent of state changes between
+// the consecutive results, oldest first. Like in Nagios, the recent changes
+// weigh more: from 0.8 for the oldest change to 1.2 for the newest one.
+func stateChange(results []bool) float64 {
+	n := len(results) - 1
+	if n < 1 {
+		return 0
+	}
+
+	var change, total float64
+	for i := 1; i <= n; i++ {
+		weight := 0.8
+		if n > 1 {
+			weight += 0.4 * float64(i-1) / float64(n-1)
+		}
+		if results[i] != results[i-1] {
+			change += weight
+		}
+		total += weight
+	}
+	return change * 100 / total
+}
+
+// updateFlapping records the check result of the target and detects
+// whether it starts or stops flapping. The target flaps once its state change
+// goes above the high threshold, and settles once it goes below the low one.
+// It reports whether the target has just settled.
+func (c *Checker) updateFlapping(t *target, ok bool) bool {
+	if c.FlapWindow <= 0 {
+		return false
+	}
+
+	t.results = append(t.results, ok)
+	if len(t.results) > c.FlapWindow+1 {
+		t.results = t.results[len(t.results)-c.FlapWindow-1:]
+	}
+	if len(t.results) <= c.FlapWindow {
+		return false
+	}
+
+	high, low := c.flapThresholds()
+	change := stateChange(t.results)
+	switch {
+	case !t.flapping && change > high:
+		t.flapping = true
+		t.flapHealth = t.healthy
+		c.slogger.Warnf("Target %s is flapping, %.1f%% state change", t.name, change)
+		c.publish(Event{
+			Type:       TargetFlapping,
+			Target:     t.name,
+			Namespace:  t.namespace,
+			Healthy:    t.healthy,
+			Status:     string(ServiceFlapping),
+			Suppressed: t.muted,
+		})
+	case t.flapping && change < low:
+		t.flapping = false
+		c.slogger.Infof("Target %s stopped flapping, %.1f%% state change", t.name, change)
+		return true
+	}
+	return false
+}
+
+// countedHealthy returns the health state counted in the cluster and namespace statuses.
+// It is frozen while the target flaps, so that the statuses don't flap along with it.
+func (t *target) countedHealthy() bool {
+	if t.flapping {
+		return t.flapHealth
+	}
+	return t.healthy
+}
+
+// settle publishes the health state the target settled on after flapping
+func (c *Checker) settle(t *target) {
+	e := Event{
+		Type:       TargetSettled,
+		Target:     t.name,
+		Namespace:  t.namespace,
+		Healthy:    t.healthy,
+		Status:     string(ServiceHealthy),
+		Suppressed: t.muted,
+	}
+	if !t.healthy {
+		e.Status = string(ServiceFailed)
+		if t.lastReport.err != nil {
+			e.Error = t.lastReport.err.Error()
+			e.ErrorClass = t.lastReport.err.Class
+		}
+	}
+	c.publish(e)
+}
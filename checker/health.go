@@ -0,0 +1,184 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// loopHeartbeatAge is the maximum time between two heartbeats of the run loop
+// and the scheduler before they are considered stuck
+const loopHeartbeatAge = 30 * time.Second
+
+// Check is the result of a single internal condition of the checker
+type Check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// AddSource registers a discovery source that has to complete
+// its initial sync before the checker gets ready
+func (c *Checker) AddSource(name string) {
+	c.accessors <- func(c *Checker) {
+		if _, ok := c.sources[name]; !ok {
+			c.sources[name] = false
+		}
+	}
+}
+
+// SourceSynced marks the initial sync of the discovery source as completed.
+// Once all the sources are synced, the restored states of the targets not added
+// again are dropped, as their services were deleted while the checker was down.
+func (c *Checker) SourceSynced(name string) {
+	c.accessors <- func(c *Checker) {
+		c.sources[name] = true
+		c.slogger.Infof("Discovery source %s synced", name)
+		if c.synced() && len(c.restored) > 0 {
+			c.slogger.Infof("Dropping the restored states of %d deleted targets", len(c.restored))
+			c.restored = make(map[string]TargetState)
+		}
+		c.updateFirstRound()
+		c.updateHealthStatus()
+	}
+}
+
+// synced reports whether all the discovery sources completed their initial sync
+func (c *Checker) synced() bool {
+	for _, synced := range c.sources {
+		if !synced {
+			return false
+		}
+	}
+	return true
+}
+
+// updateFirstRound records the end of the first probe round, once all the discovery
+// sources completed their initial sync and all the targets have been probed
+func (c *Checker) updateFirstRound() {
+	if c.firstRound || !c.synced() {
+		return
+	}
+	for _, t := range c.targets {
+		if !t.probed {
+			return
+		}
+	}
+	c.firstRound = true
+	c.slogger.Infof("First probe round of %d targets completed", len(c.targets))
+}
+
+// ReadinessChecks reports the conditions of the checker readiness
+func (c *Checker) ReadinessChecks() []Check {
+	result := make(chan []Check, 1)
+	c.accessors <- func(c *Checker) {
+		result <- c.readinessChecks()
+	}
+	return <-result
+}
+
+func (c *Checker) readinessChecks() []Check {
+	var pending []string
+	for name, synced := range c.sources {
+		if !synced {
+			pending = append(pending, name)
+		}
+	}
+	sort.Strings(pending)
+	discovery := Check{Name: "discovery", OK: len(pending) == 0}
+	if !discovery.OK {
+		discovery.Message = fmt.Sprintf("waiting for the initial sync of %s", strings.Join(pending, ", "))
+	}
+
+	// The targets added later, e.g. by a service update, don't make the checker unready
+	targets := Check{Name: "targets", OK: true}
+	if !c.firstRound {
+		unprobed := 0
+		for _, t := range c.targets {
+			if !t.probed {
+				unprobed++
+			}
+		}
+		if unprobed > 0 {
+			targets.OK = false
+			targets.Message = fmt.Sprintf("%d of %d targets not probed yet", unprobed, len(c.targets))
+		}
+	}
+
+	notifier := Check{Name: "notifier", OK: len(c.events) < cap(c.events)}
+	if !notifier.OK {
+		notifier.Message = fmt.Sprintf("event queue is full (%d events)", cap(c.events))
+	}
+
+	return []Check{discovery, targets, notifier}
+}
+
+// heartbeat is the last sign of life of an internal subsystem
+type heartbeat struct {
+	last   time.Time
+	maxAge time.Duration
+}
+
+// Heartbeat records a sign of life of the named subsystem.
+// The subsystem is considered stuck when no heartbeat comes within maxAge.
+// It is safe to call even when the run loop is stuck.
+func (c *Checker) Heartbeat(name string, maxAge time.Duration) {
+	c.beatMux.Lock()
+	defer c.beatMux.Unlock()
+
+	c.beats[name] = &heartbeat{last: time.Now(), maxAge: maxAge}
+}
+
+// LivenessChecks reports the heartbeat of each internal subsystem.
+// Unlike the other reports, it doesn't depend on the run loop,
+// so it reports a stuck run loop instead of getting stuck with it.
+func (c *Checker) LivenessChecks() []Check {
+	c.beatMux.Lock()
+	defer c.beatMux.Unlock()
+
+	now := time.Now()
+	checks := make([]Check, 0, len(c.beats))
+	for name, beat := range c.beats {
+		check := Check{Name: name, OK: now.Sub(beat.last) <= beat.maxAge}
+		if !check.OK {
+			check.Message = fmt.Sprintf("no heartbeat since %s", beat.last.Format(time.RFC3339))
+		}
+		checks = append(checks, check)
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Name < checks[j].Name
+	})
+	return checks
+}
+
+// Alive reports whether all the internal subsystems are alive
+func (c *Checker) Alive() bool {
+	return passed(c.LivenessChecks())
+}
+
+// beatLoops records the heartbeat of the run loop and, if no target
+// has missed its check, the heartbeat of the scheduler of the target checks
+func (c *Checker) beatLoops(now time.Time) {
+	c.Heartbeat("checker", loopHeartbeatAge)
+
+	for _, t := range c.targets {
+		last := time.Unix(0, atomic.LoadInt64(&t.lastProbe))
+		if now.Sub(last) > 2*c.Interval+loopHeartbeatAge {
+			c.slogger.Warnf("Target %s missed its checks since %s", t.name, last.Format(time.RFC3339))
+			return
+		}
+	}
+	c.Heartbeat("scheduler", loopHeartbeatAge)
+}
+
+// passed reports whether all the checks are OK
+func passed(checks []Check) bool {
+	for _, check := range checks {
+		if !check.OK {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package checker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxHealthBody is the maximum size of a health response body read for its status
+const maxHealthBody = 1 << 20
+
+// Component reports a part of a service, e.g. its database, from its structured health response
+type Component struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`           // Status reported by the service, e.g. UP or warn
+	Output string `json:"output,omitempty"` // Error or details reported by the service, if any
+}
+
+// healthLevel is the meaning of a status of a structured health response
+type healthLevel int
+
+const (
+	healthUnknown healthLevel = iota
+	healthPass
+	healthWarn
+	healthFail
+)
+
+// parseHealthLevel maps the statuses of the application/health+json, Spring Boot
+// actuator and MicroProfile Health formats to their meaning
+func parseHealthLevel(status string) healthLevel {
+	switch strings.ToLower(status) {
+	case "pass", "ok", "up":
+		return healthPass
+	case "warn", "unknown":
+		return healthWarn
+	case "fail", "error", "down", "out_of_service":
+		return healthFail
+	default:
+		return healthUnknown
+	}
+}
+
+// healthDocument is the union of the supported structured health response formats:
+// application/health+json with the checks by name, MicroProfile Health
+// with the list of checks and Spring Boot actuator with the nested components
+type healthDocument struct {
+	Status     string                     `json:"status"`
+	Output     string                     `json:"output,omitempty"`
+	Checks     json.RawMessage            `json:"checks,omitempty"`
+	Components map[string]springComponent `json:"components,omitempty"`
+}
+
+// healthCheck is a check of the application/health+json format
+type healthCheck struct {
+	ComponentID string `json:"componentId"`
+	Status      string `json:"status"`
+	Output      string `json:"output"`
+}
+
+// microProfileCheck is a check of the MicroProfile Health format
+type microProfileCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// springComponent is a component of the Spring Boot actuator format
+type springComponent struct {
+	Status     string                     `json:"status"`
+	Details    map[string]interface{}     `json:"details"`
+	Components map[string]springComponent `json:"components"`
+}
+
+// healthResponse is the outcome of a structured health response
+type healthResponse struct {
+	status     string
+	level      healthLevel
+	output     string
+	components []Component
+}
+
+// readHealth reads the structured health response, if any. It returns nil
+// for the other responses, e.g. the plain text ones.
+func readHealth(resp *http.Response) *healthResponse {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+	if err != nil {
+		return nil
+	}
+	var doc healthDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	level := parseHealthLevel(doc.Status)
+	if level == healthUnknown {
+		return nil
+	}
+
+	health := &healthResponse{status: doc.Status, level: level, output: doc.Output}
+	var byName map[string][]healthCheck
+	var list []microProfileCheck
+	switch {
+	case json.Unmarshal(doc.Checks, &byName) == nil:
+		for name, checks := range byName {
+			for i, check := range checks {
+				component := Component{Name: name, Status: check.Status, Output: check.Output}
+				if len(checks) > 1 {
+					component.Name += "/" + first(check.ComponentID, strconv.Itoa(i))
+				}
+				health.components = append(health.components, component)
+			}
+		}
+	case json.Unmarshal(doc.Checks, &list) == nil:
+		for _, check := range list {
+			health.components = append(health.components, Component{Name: check.Name, Status: check.Status})
+		}
+	}
+	appendSpringComponents(health, "", doc.Components)
+
+	sort.SliceStable(health.components, func(i, j int) bool {
+		return health.components[i].Name < health.components[j].Name
+	})
+	return health
+}
+
+// appendSpringComponents flattens the nested components, e.g. db/primary
+func appendSpringComponents(health *healthResponse, prefix string, components map[string]springComponent) {
+	for name, component := range components {
+		c := Component{Name: prefix + name, Status: component.Status}
+		if err, ok := component.Details["error"].(string); ok {
+			c.Output = err
+		}
+		health.components = append(health.components, c)
+		appendSpringComponents(health, c.Name+"/", component.Components)
+	}
+}
+
+// problems describes the components reported at the given level, or the whole response
+func (h *healthResponse) problems(level healthLevel) string {
+	var names []string
+	for _, c := range h.components {
+		if parseHealthLevel(c.Status) == level {
+			names = append(names, c.Name)
+		}
+	}
+
+	message := fmt.Sprintf("health status %s", h.status)
+	switch {
+	case len(names) > 0:
+		message += ": " + strings.Join(names, ", ")
+	case h.output != "":
+		message += ": " + h.output
+	}
+	return message
+}
+
+// checkHealth applies the structured health response to the report and to the error
+// of its status code, if any. A failed response fails the check, even with the 200
+// status code, and a response with warnings degrades the service.
+func checkHealth(r *report, health *healthResponse, statusErr error) error {
+	r.components = health.components
+	switch health.level {
+	case healthFail:
+		if statusErr == nil {
+			return &CheckError{Class: ErrorAssertion, Err: errors.New(health.problems(healthFail))}
+		}
+		checkErr := classify(statusErr)
+		return &CheckError{
+			Class:      checkErr.Class,
+			StatusCode: checkErr.StatusCode,
+			Err:        fmt.Errorf("%v, %s", checkErr.Err, health.problems(healthFail)),
+		}
+	case healthWarn:
+		r.warning = health.problems(healthWarn)
+	}
+	return statusErr
+}
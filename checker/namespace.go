@@ -0,0 +1,113 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+)
+
+// NamespaceStatus describes the health status of the services of a namespace.
+// It is evaluated like the cluster status, with the threshold of the namespace.
+type NamespaceStatus struct {
+	Name      string        `json:"name"`
+	Healthy   bool          `json:"healthy"`
+	Status    ClusterStatus `json:"status"`
+	Reason    string        `json:"reason,omitempty"`
+	Threshold int           `json:"threshold"` // Percentage of healthy services for the namespace to be healthy
+	Total     int           `json:"total"`     // Number of services counted in the status
+	Failed    int           `json:"failed"`
+	Muted     int           `json:"muted"`
+	Impacted  int           `json:"impacted"`
+}
+
+// SetNamespaceThreshold overrides the percentage of healthy services for the namespace
+// to be healthy, e.g. from a namespace annotation. The threshold 0 restores the cluster one.
+func (c *Checker) SetNamespaceThreshold(namespace string, threshold int) error {
+	if threshold < 0 || threshold > 100 {
+		return fmt.Errorf("invalid threshold %d of namespace %s, want 0 to 100", threshold, namespace)
+	}
+
+	done := make(chan struct{})
+	c.accessors <- func(c *Checker) {
+		if threshold == 0 {
+			delete(c.namespaceThresholds, namespace)
+		} else {
+			c.namespaceThresholds[namespace] = threshold
+			c.slogger.Infof("Threshold of namespace %s set to %d%%", namespace, threshold)
+		}
+		close(done)
+	}
+	<-done
+	return nil
+}
+
+// Namespaces reports the status of each namespace with checked services, sorted by name
+func (c *Checker) Namespaces() []NamespaceStatus {
+	result := make(chan []NamespaceStatus, 1)
+	c.accessors <- func(c *Checker) {
+		result <- c.namespaceStatuses()
+	}
+	return <-result
+}
+
+// NamespaceStatus reports the status of the namespace, and whether it has checked services
+func (c *Checker) NamespaceStatus(namespace string) (NamespaceStatus, bool) {
+	for _, ns := range c.Namespaces() {
+		if ns.Name == namespace {
+			return ns, true
+		}
+	}
+	return NamespaceStatus{}, false
+}
+
+// namespaceStatuses evaluates the namespaces, counting their services like updateHealthStatus.
+// The missing services only count in the cluster status, as their namespace may be unknown.
+func (c *Checker) namespaceStatuses() []NamespaceStatus {
+	byName := make(map[string]*NamespaceStatus)
+	healthy := make(map[string]int)
+	for _, t := range c.targets {
+		if t.state == 0 || t.namespace == "" {
+			continue
+		}
+		ns, ok := byName[t.namespace]
+		if !ok {
+			ns = &NamespaceStatus{Name: t.namespace, Threshold: c.StateThreshold}
+			if threshold, ok := c.namespaceThresholds[t.namespace]; ok {
+				ns.Threshold = threshold
+			}
+			byName[t.namespace] = ns
+		}
+
+		switch {
+		case t.muted:
+			ns.Muted++
+		case len(t.impactedBy) > 0:
+			ns.Impacted++
+			if c.CountImpacted {
+				ns.Total++
+				ns.Failed++
+			}
+		default:
+			ns.Total++
+			if t.countedHealthy() {
+				healthy[t.namespace]++
+			} else {
+				ns.Failed++
+			}
+		}
+	}
+
+	statuses := make([]NamespaceStatus, 0, len(byName))
+	for name, ns := range byName {
+		ns.Status = ClusterHealthy
+		if !calcHealthStatus(ns.Total, healthy[name], ns.Threshold) {
+			ns.Status = ClusterUnhealthy
+			ns.Reason = fmt.Sprintf("%d of %d services failed", ns.Failed, ns.Total)
+		}
+		ns.Healthy = ns.Status == ClusterHealthy
+		statuses = append(statuses, *ns)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
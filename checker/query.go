@@ -0,0 +1,203 @@
+package checker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ServiceSort is the order of the services in the query results
+type ServiceSort string
+
+const (
+	// SortByStatus lists the root causes of the failures first, as SortServices
+	SortByStatus ServiceSort = "status"
+	// SortByName lists the services by name
+	SortByName ServiceSort = "name"
+)
+
+// ServiceQuery selects, orders and pages the services of the cluster state.
+// The empty query lists all the services sorted by status.
+type ServiceQuery struct {
+	Namespace string          // Only the services of the namespace, if set
+	Statuses  []ServiceStatus // Only the services with any of the statuses, if set
+	Selector  string          // Only the services matching the label selector, if set
+	Prefix    string          // Only the services with the name prefix, if set
+	Sort      ServiceSort     // Order of the services, by status by default
+	Limit     int             // Maximum number of services, unlimited if 0
+	Continue  string          // Token of the page following a previous result, if set
+
+	// Namespaces also summarizes the status of each namespace
+	Namespaces bool
+}
+
+// queryKey is a selected service, holding what orders it until its page is reported
+type queryKey struct {
+	name   string
+	rank   int
+	target *target // checked target, or nil if the service is missing
+}
+
+// queryPosition is the position of the last service of a page, encoded in the continue token
+type queryPosition struct {
+	Sort ServiceSort `json:"s"`
+	Rank int         `json:"r,omitempty"`
+	Name string      `json:"n"`
+}
+
+// encode returns the continue token of the position
+func (p *queryPosition) encode() string {
+	data, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePosition parses the continue token
+func decodePosition(token string) (*queryPosition, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid continue token")
+	}
+	var p queryPosition
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid continue token")
+	}
+	return &p, nil
+}
+
+// precedes reports whether the position is before the service in the sort order
+func (p *queryPosition) precedes(k *queryKey) bool {
+	if p.Sort == SortByStatus && k.rank != p.Rank {
+		return p.Rank < k.rank
+	}
+	return p.Name < k.name
+}
+
+// ParseServiceStatuses parses the comma separated service statuses
+func ParseServiceStatuses(s string) ([]ServiceStatus, error) {
+	var statuses []ServiceStatus
+	for _, part := range strings.Split(s, ",") {
+		status := ServiceStatus(strings.TrimSpace(part))
+		switch status {
+		case "":
+			continue
+		case ServiceHealthy, ServiceFailed, ServiceDegraded, ServiceFlapping, ServiceImpacted, ServiceMissing:
+			statuses = append(statuses, status)
+		default:
+			return nil, fmt.Errorf("unknown service status %q", status)
+		}
+	}
+	return statuses, nil
+}
+
+// matchesStatus reports whether the query selects the service status
+func (q *ServiceQuery) matchesStatus(status ServiceStatus) bool {
+	if len(q.Statuses) == 0 {
+		return true
+	}
+	for _, s := range q.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Query reports about the current cluster state with the selected services.
+// The services are filtered, sorted and paged while the state is read, and only
+// the ones of the page are reported.
+func (c *Checker) Query(q ServiceQuery) (ClusterState, error) {
+	selector, err := labels.Parse(q.Selector)
+	if err != nil {
+		return ClusterState{}, fmt.Errorf("invalid label selector: %v", err)
+	}
+	switch q.Sort {
+	case "":
+		q.Sort = SortByStatus
+	case SortByStatus, SortByName:
+	default:
+		return ClusterState{}, fmt.Errorf("unknown sort order %q", q.Sort)
+	}
+	if q.Limit < 0 {
+		return ClusterState{}, fmt.Errorf("invalid limit %d", q.Limit)
+	}
+	var after *queryPosition
+	if q.Continue != "" {
+		if after, err = decodePosition(q.Continue); err != nil {
+			return ClusterState{}, err
+		}
+		if after.Sort != q.Sort {
+			return ClusterState{}, fmt.Errorf("continue token of another sort order")
+		}
+	}
+
+	result := make(chan *ClusterState, 1)
+	c.accessors <- func(c *Checker) {
+		var keys []queryKey
+		for name, t := range c.targets {
+			if t.state == 0 || !strings.HasPrefix(name, q.Prefix) || !matchTarget(t, "", q.Namespace, selector) {
+				continue
+			}
+			if status := serviceStatus(t); q.matchesStatus(status) {
+				keys = append(keys, queryKey{name: name, rank: statusRank(status, t.healthy), target: t})
+			}
+		}
+		if q.Namespace == "" && selector.Empty() && q.matchesStatus(ServiceMissing) {
+			for name := range c.missing {
+				if strings.HasPrefix(name, q.Prefix) {
+					keys = append(keys, queryKey{name: name, rank: statusRank(ServiceMissing, false)})
+				}
+			}
+		}
+
+		cs := &ClusterState{Cluster: c.cluster()}
+		keys, cs.Continue = q.page(keys, after)
+		cs.Services = make([]Service, 0, len(keys))
+		for _, k := range keys {
+			if k.target == nil {
+				cs.Services = append(cs.Services, Service{
+					Name:   k.name,
+					Status: ServiceMissing,
+					Error:  "service is missing",
+				})
+				continue
+			}
+			cs.Services = append(cs.Services, c.service(k.name, k.target))
+		}
+		if q.Namespaces {
+			cs.Namespaces = c.namespaceStatuses()
+		}
+		result <- cs
+	}
+	return *<-result, nil
+}
+
+// page sorts the selected services and returns the ones of the page following the position,
+// if any, with the continue token of the next page
+func (q *ServiceQuery) page(keys []queryKey, after *queryPosition) ([]queryKey, string) {
+	sort.Slice(keys, func(i, j int) bool {
+		if q.Sort == SortByStatus && keys[i].rank != keys[j].rank {
+			return keys[i].rank < keys[j].rank
+		}
+		return keys[i].name < keys[j].name
+	})
+
+	if after != nil {
+		i := sort.Search(len(keys), func(i int) bool {
+			return after.precedes(&keys[i])
+		})
+		keys = keys[i:]
+	}
+	if q.Limit == 0 || len(keys) <= q.Limit {
+		return keys, ""
+	}
+	last := &keys[q.Limit-1]
+	position := queryPosition{Sort: q.Sort, Name: last.name}
+	if q.Sort == SortByStatus {
+		position.Rank = last.rank
+	}
+	return keys[:q.Limit], position.encode()
+}
@@ -0,0 +1,176 @@
+package checker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// credentialReloadInterval is the time between two reads of the request values of a target,
+// so that the rotated credentials are picked up
+const credentialReloadInterval = time.Minute
+
+// redacted replaces the secret values in the reports
+const redacted = "<redacted>"
+
+// ValueSource provides a value of the check request, e.g. a token
+type ValueSource interface {
+	Value() (string, error)
+}
+
+// Literal is a value given in the target definition
+type Literal string
+
+// Value returns the literal value
+func (l Literal) Value() (string, error) {
+	return string(l), nil
+}
+
+// EnvValue reads the value from the named environment variable
+type EnvValue string
+
+// Value reads the environment variable
+func (e EnvValue) Value() (string, error) {
+	value, ok := os.LookupEnv(string(e))
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", string(e))
+	}
+	return value, nil
+}
+
+// FileValue reads the value from the file at the given path.
+// The trailing newline is dropped.
+type FileValue string
+
+// Value reads the file
+func (f FileValue) Value() (string, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// BasicAuth holds the basic authentication credentials
+type BasicAuth struct {
+	Username string
+	Password ValueSource
+}
+
+// RequestConfig holds the headers and the credentials of the check request of a target
+type RequestConfig struct {
+	Headers     map[string]ValueSource // Request headers, including Host
+	BearerToken ValueSource            // Sent in the Authorization header, if set
+	BasicAuth   *BasicAuth             // Sent in the Authorization header, if set
+}
+
+// sensitiveHeaders are the parts of the header names whose literal values are redacted
+var sensitiveHeaders = []string{"auth", "cookie", "key", "token", "secret", "password"}
+
+// Redacted describes the request headers without revealing the secret values.
+// Only the literal values of the headers with harmless names are shown.
+func (c *RequestConfig) Redacted() map[string]string {
+	if c == nil {
+		return nil
+	}
+
+	headers := make(map[string]string, len(c.Headers)+1)
+	for name, source := range c.Headers {
+		headers[http.CanonicalHeaderKey(name)] = redacted
+		if literal, ok := source.(Literal); ok && !sensitive(name) {
+			headers[http.CanonicalHeaderKey(name)] = string(literal)
+		}
+	}
+	if c.BearerToken != nil || c.BasicAuth != nil {
+		headers["Authorization"] = redacted
+	}
+	return headers
+}
+
+func sensitive(header string) bool {
+	header = strings.ToLower(header)
+	for _, part := range sensitiveHeaders {
+		if strings.Contains(header, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve reads all the values of the request headers
+func (c *RequestConfig) resolve() (http.Header, error) {
+	header := make(http.Header)
+
+	names := make([]string, 0, len(c.Headers))
+	for name := range c.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := c.Headers[name].Value()
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %v", name, err)
+		}
+		header.Set(name, value)
+	}
+
+	switch {
+	case c.BearerToken != nil:
+		token, err := c.BearerToken.Value()
+		if err != nil {
+			return nil, fmt.Errorf("bearer token: %v", err)
+		}
+		header.Set("Authorization", "Bearer "+token)
+	case c.BasicAuth != nil:
+		req := http.Request{Header: header}
+		password, err := c.BasicAuth.Password.Value()
+		if err != nil {
+			return nil, fmt.Errorf("basic auth password: %v", err)
+		}
+		req.SetBasicAuth(c.BasicAuth.Username, password)
+	}
+	return header, nil
+}
+
+// targetRequest builds the check requests of a single target.
+type targetRequest struct {
+	url        string
+	config     *RequestConfig
+	header     http.Header
+	resolvedAt time.Time
+}
+
+// get returns a new check request, reading the values again when they are due.
+// If the values can't be read again, the previous ones are kept.
+func (tr *targetRequest) get(now time.Time) (*http.Request, error) {
+	var resolveErr error
+	if tr.config != nil && (tr.header == nil || now.Sub(tr.resolvedAt) >= credentialReloadInterval) {
+		tr.resolvedAt = now
+		header, err := tr.config.resolve()
+		switch {
+		case err == nil:
+			tr.header = header
+		case tr.header == nil:
+			return nil, fmt.Errorf("reading request values: %v", err)
+		default:
+			resolveErr = fmt.Errorf("reading request values again: %v", err)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, tr.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range tr.header {
+		if name == "Host" {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[name] = values
+	}
+	return req, resolveErr
+}
@@ -0,0 +1,35 @@
+package checker
+
+import "time"
+
+// pacer decides the time before the next check of a target.
+// While the target changes its state, i.e. its last checks haven't reached
+// the threshold of their result yet, it is checked again after the retry
+// interval, so that failures and recoveries are detected sooner.
+type pacer struct {
+	interval         time.Duration
+	retry            time.Duration
+	failureThreshold int
+	successThreshold int
+
+	ok    bool // result of the last check
+	count int  // number of consecutive checks with the same result
+}
+
+// next records the result of the check and returns the time before the next one
+func (p *pacer) next(ok bool) time.Duration {
+	if ok == p.ok {
+		p.count++
+	} else {
+		p.ok, p.count = ok, 1
+	}
+
+	threshold := p.failureThreshold
+	if ok {
+		threshold = p.successThreshold
+	}
+	if p.retry > 0 && p.retry < p.interval && p.count < threshold {
+		return p.retry
+	}
+	return p.interval
+}
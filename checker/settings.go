@@ -0,0 +1,158 @@
+package checker
+
+import (
+	"fmt"
+)
+
+// ServiceSettings configures the checks of a service in the config file, in place of its
+// annotations. Unlike the annotations, only the operator controls the config file, so the
+// request values and the TLS material may be read from the environment and the files of healthcat.
+type ServiceSettings struct {
+	Service string           `json:"service"`           // Target name, including the synthetic checks
+	Request *RequestSettings `json:"request,omitempty"` // Replaces the request annotation of the service, if set
+	TLS     *TLSSettings     `json:"tls,omitempty"`     // Replaces the TLS annotations of the service, if set
+
+	request *RequestConfig
+	tls     *TLSConfig
+}
+
+// RequestSettings are the headers and the credentials of the check request of a service
+type RequestSettings struct {
+	Headers     map[string]ValueRef `json:"headers,omitempty"`
+	BearerToken *ValueRef           `json:"bearerToken,omitempty"`
+	BasicAuth   *BasicAuthSettings  `json:"basicAuth,omitempty"`
+}
+
+// BasicAuthSettings are the basic authentication credentials of the check request
+type BasicAuthSettings struct {
+	Username string   `json:"username"`
+	Password ValueRef `json:"password"`
+}
+
+// ValueRef is a request value given literally in value, or read from
+// the named environment variable or from the file at the given path
+type ValueRef struct {
+	Value string `json:"value,omitempty"`
+	Env   string `json:"env,omitempty"`
+	File  string `json:"file,omitempty"`
+}
+
+// TLSSettings are the TLS settings of the https checks of a service,
+// the CA bundle and the client certificate being read from PEM files
+type TLSSettings struct {
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// validate checks the service settings and builds their request and TLS configurations
+func (s *ServiceSettings) validate() error {
+	if s.Service == "" {
+		return fmt.Errorf("service settings must define the service")
+	}
+	if s.Request != nil {
+		request, err := s.Request.config()
+		if err != nil {
+			return fmt.Errorf("request of %s: %v", s.Service, err)
+		}
+		s.request = request
+	}
+	if s.TLS != nil {
+		if (s.TLS.CertFile == "") != (s.TLS.KeyFile == "") {
+			return fmt.Errorf("TLS of %s must define both the client certificate and its key", s.Service)
+		}
+		s.tls = s.TLS.config()
+	}
+	return nil
+}
+
+// config creates the TLS configuration of the settings
+func (t *TLSSettings) config() *TLSConfig {
+	config := &TLSConfig{ServerName: t.ServerName, InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CAFile != "" || t.CertFile != "" {
+		config.Source = &FileTLSSource{CAFile: t.CAFile, CertFile: t.CertFile, KeyFile: t.KeyFile}
+	}
+	return config
+}
+
+// config creates the request configuration of the settings
+func (r *RequestSettings) config() (*RequestConfig, error) {
+	config := &RequestConfig{Headers: make(map[string]ValueSource, len(r.Headers))}
+	for name, ref := range r.Headers {
+		source, err := ref.source()
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %v", name, err)
+		}
+		config.Headers[name] = source
+	}
+	if r.BearerToken != nil {
+		source, err := r.BearerToken.source()
+		if err != nil {
+			return nil, fmt.Errorf("bearer token: %v", err)
+		}
+		config.BearerToken = source
+	}
+	if r.BasicAuth != nil {
+		source, err := r.BasicAuth.Password.source()
+		if err != nil {
+			return nil, fmt.Errorf("basic auth password: %v", err)
+		}
+		config.BasicAuth = &BasicAuth{Username: r.BasicAuth.Username, Password: source}
+	}
+	return config, nil
+}
+
+// source creates the value source of the reference
+func (r *ValueRef) source() (ValueSource, error) {
+	switch {
+	case r.Env != "" && r.File != "", r.Value != "" && (r.Env != "" || r.File != ""):
+		return nil, fmt.Errorf("value must define only one of value, env and file")
+	case r.Env != "":
+		return EnvValue(r.Env), nil
+	case r.File != "":
+		return FileValue(r.File), nil
+	default:
+		return Literal(r.Value), nil
+	}
+}
+
+// Redacted describes the service settings without revealing the secret values.
+// The literal values of the credentials and of the headers with sensitive names are redacted,
+// the names of the environment variables and the paths of the files are shown.
+func (s ServiceSettings) Redacted() ServiceSettings {
+	if s.Request == nil {
+		return s
+	}
+
+	request := *s.Request
+	if len(request.Headers) > 0 {
+		request.Headers = make(map[string]ValueRef, len(s.Request.Headers))
+		for name, ref := range s.Request.Headers {
+			if sensitive(name) {
+				ref = ref.redacted()
+			}
+			request.Headers[name] = ref
+		}
+	}
+	if request.BearerToken != nil {
+		token := request.BearerToken.redacted()
+		request.BearerToken = &token
+	}
+	if request.BasicAuth != nil {
+		auth := *request.BasicAuth
+		auth.Password = auth.Password.redacted()
+		request.BasicAuth = &auth
+	}
+	s.Request = &request
+	return s
+}
+
+// redacted returns the reference with its literal value redacted
+func (r ValueRef) redacted() ValueRef {
+	if r.Value != "" {
+		r.Value = redacted
+	}
+	return r
+}
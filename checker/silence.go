@@ -0,0 +1,202 @@
+package checker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// annotationSilencePrefix prefixes the IDs of the silences created for
+// targets with an end time of a silence in their spec
+const annotationSilencePrefix = "annotation:"
+
+// Silence mutes the matching targets for a time window.
+// Muted targets are still checked and listed, but they are excluded from
+// the cluster health status and their transitions are published as suppressed.
+//
+// A target matches the silence if it matches all the non-empty criteria:
+// the exact target name, the namespace and the label selector.
+type Silence struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`      // Exact target name
+	Namespace string    `json:"namespace,omitempty"` // Target namespace
+	Selector  string    `json:"selector,omitempty"`  // Target label selector, e.g. "app=web,tier!=db"
+	StartsAt  time.Time `json:"startsAt"`            // Start of the window, defaults to the creation time
+	EndsAt    time.Time `json:"endsAt"`              // End of the window
+	CreatedBy string    `json:"createdBy"`           // Who created the silence
+	Comment   string    `json:"comment"`             // Why the silence was created
+
+	selector   labels.Selector
+	configured bool // Defined by the checker configuration, which is not persisted
+}
+
+// validate checks the silence definition and completes the default values
+func (s *Silence) validate(now time.Time) error {
+	if s.Name == "" && s.Namespace == "" && s.Selector == "" {
+		return errors.New("silence must define a name, a namespace or a selector")
+	}
+	if s.CreatedBy == "" {
+		return errors.New("silence must define the creator")
+	}
+	if s.Comment == "" {
+		return errors.New("silence must define a comment")
+	}
+	if s.StartsAt.IsZero() {
+		s.StartsAt = now
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("silence must end after it starts")
+	}
+
+	selector, err := labels.Parse(s.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid silence selector: %v", err)
+	}
+	s.selector = selector
+
+	if s.ID == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		s.ID = hex.EncodeToString(id)
+	}
+	return nil
+}
+
+// sameDefinition reports whether the silences mute the same targets for the same reason and time,
+// regardless of their IDs and of the default start time
+func (s *Silence) sameDefinition(other *Silence) bool {
+	return s.Name == other.Name && s.Namespace == other.Namespace && s.Selector == other.Selector &&
+		s.EndsAt.Equal(other.EndsAt) && s.CreatedBy == other.CreatedBy && s.Comment == other.Comment
+}
+
+// active reports whether the silence window includes the given time
+func (s *Silence) active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+// matches reports whether the silence applies to the target
+func (s *Silence) matches(t *target) bool {
+	return matchTarget(t, s.Name, s.Namespace, s.selector)
+}
+
+// AddSilence validates and registers the silence.
+// It returns the registered silence with the generated ID. A silence with an ID
+// is refused, so that it can't replace the silence of the config or of an annotation.
+func (c *Checker) AddSilence(s Silence) (Silence, error) {
+	if s.ID != "" {
+		return Silence{}, errors.New("silence ID is generated and can't be set")
+	}
+	if err := s.validate(time.Now()); err != nil {
+		return Silence{}, err
+	}
+
+	done := make(chan struct{})
+	c.accessors <- func(c *Checker) {
+		c.addSilence(&s)
+		close(done)
+	}
+	<-done
+	return s, nil
+}
+
+// DeleteSilence removes the silence with the given ID.
+// It returns false if there is no such silence.
+func (c *Checker) DeleteSilence(id string) bool {
+	result := make(chan bool, 1)
+	c.accessors <- func(c *Checker) {
+		_, ok := c.silences[id]
+		if ok {
+			delete(c.silences, id)
+			c.slogger.Infof("Removed silence %s", id)
+			c.updateMuted(time.Now())
+		}
+		result <- ok
+	}
+	return <-result
+}
+
+// ListSilences returns the registered silences ordered by their end time
+func (c *Checker) ListSilences() []Silence {
+	result := make(chan []Silence, 1)
+	c.accessors <- func(c *Checker) {
+		silences := make([]Silence, 0, len(c.silences))
+		for _, s := range c.silences {
+			silences = append(silences, *s)
+		}
+		result <- silences
+	}
+
+	silences := <-result
+	sort.Slice(silences, func(i, j int) bool {
+		return silences[i].EndsAt.Before(silences[j].EndsAt)
+	})
+	return silences
+}
+
+func (c *Checker) addSilence(s *Silence) {
+	c.silences[s.ID] = s
+	c.slogger.Infof("Added silence %s by %s until %s: %s", s.ID, s.CreatedBy, s.EndsAt.Format(time.RFC3339), s.Comment)
+	c.updateMuted(time.Now())
+}
+
+// silenceTarget registers or removes the silence of a single target
+// requested by the end time in the target spec.
+// The caller is responsible for updating the muted targets.
+func (c *Checker) silenceTarget(t *target, until time.Time) {
+	id := annotationSilencePrefix + t.name
+	if until.IsZero() {
+		delete(c.silences, id)
+		return
+	}
+
+	s := &Silence{
+		ID:        id,
+		Name:      t.name,
+		EndsAt:    until,
+		CreatedBy: "annotation",
+		Comment:   "Silenced by the service annotation",
+	}
+	if err := s.validate(time.Now()); err != nil {
+		c.slogger.Warnf("Ignoring silence of target %s: %v", t.name, err)
+		return
+	}
+	c.silences[id] = s
+	c.slogger.Infof("Silenced target %s until %s", t.name, until.Format(time.RFC3339))
+}
+
+// updateMuted expires the silences that ended, marks the targets
+// muted by the active silences and updates the cluster health status
+func (c *Checker) updateMuted(now time.Time) {
+	for id, s := range c.silences {
+		if !now.Before(s.EndsAt) {
+			delete(c.silences, id)
+			c.slogger.Infof("Silence %s expired", id)
+		}
+	}
+
+	changed := false
+	for _, t := range c.targets {
+		muted := false
+		for _, s := range c.silences {
+			if s.active(now) && s.matches(t) {
+				muted = true
+				break
+			}
+		}
+		if muted != t.muted {
+			t.muted = muted
+			changed = true
+		}
+	}
+
+	if changed {
+		c.updateHealthStatus()
+	}
+}
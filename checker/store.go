@@ -0,0 +1,216 @@
+package checker
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultSnapshotInterval = 30 * time.Second
+
+// Snapshot is the persisted state of the checker
+type Snapshot struct {
+	Time     time.Time              `json:"time"`
+	Targets  map[string]TargetState `json:"targets"`
+	Silences []Silence              `json:"silences"`
+}
+
+// TargetState is the persisted state of a single target
+type TargetState struct {
+	State     int64     `json:"state"`           // Consecutive successful (positive) or failed (negative) checks
+	Healthy   bool      `json:"healthy"`         // Health state
+	Settled   bool      `json:"settled"`         // Whether the target has reached a threshold
+	LastCheck time.Time `json:"lastCheck"`       // Time of the last check
+	Error     string    `json:"error,omitempty"` // Error of the last check, if failed
+
+	ErrorClass ErrorClass `json:"errorClass,omitempty"` // Class of the error of the last check
+}
+
+// StateStore persists the checker state across restarts
+type StateStore interface {
+	// Load returns the last saved snapshot, or nil if there is none
+	Load() (*Snapshot, error)
+	// Save replaces the saved snapshot
+	Save(s *Snapshot) error
+}
+
+// FileStore keeps the snapshot in a local JSON file
+type FileStore struct {
+	Path string
+}
+
+// Load reads the snapshot from the file
+func (f *FileStore) Load() (*Snapshot, error) {
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Save writes the snapshot to a temporary file and renames it,
+// so a crash never leaves a partially written snapshot behind
+func (f *FileStore) Save(s *Snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// restore applies the saved snapshot. The target states are kept until the targets
+// are added again by the discovery, or its initial sync completes. Only the silences
+// created through the API are restored, the configured ones being defined again.
+func (c *Checker) restore() {
+	s, err := c.Store.Load()
+	if err != nil {
+		c.slogger.Errorf("Error loading the state snapshot: %v", err)
+		return
+	}
+	if s == nil {
+		c.slogger.Info("No state snapshot to restore")
+		return
+	}
+
+	for name, state := range s.Targets {
+		c.restored[name] = state
+	}
+
+	now := time.Now()
+	for i := range s.Silences {
+		silence := s.Silences[i]
+		if strings.HasPrefix(silence.ID, annotationSilencePrefix) {
+			continue
+		}
+		if _, ok := c.silences[silence.ID]; ok || c.configuredSilence(&silence) {
+			continue
+		}
+		if err := silence.validate(now); err != nil {
+			c.slogger.Debugf("Ignoring restored silence %s: %v", silence.ID, err)
+			continue
+		}
+		c.silences[silence.ID] = &silence
+	}
+
+	c.slogger.Infof("Restored state snapshot from %s with %d targets", s.Time.Format(time.RFC3339), len(s.Targets))
+}
+
+// configuredSilence reports whether the silence is defined by the configuration,
+// e.g. a copy saved with another generated ID by an older version
+func (c *Checker) configuredSilence(s *Silence) bool {
+	for _, configured := range c.silences {
+		if configured.configured && configured.sameDefinition(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreTarget applies the restored state to the newly added target
+func (c *Checker) restoreTarget(t *target) {
+	state, ok := c.restored[t.name]
+	if !ok {
+		return
+	}
+	delete(c.restored, t.name)
+
+	t.state = state.State
+	t.healthy = state.Healthy
+	t.settled = state.Settled
+	if state.State != 0 {
+		t.lastReport = &report{name: t.name, ts: state.LastCheck}
+		if state.Error != "" {
+			t.lastReport.err = &CheckError{Class: state.ErrorClass, Err: errors.New(state.Error)}
+			if t.lastReport.err.Class == "" {
+				t.lastReport.err.Class = ErrorOther
+			}
+		}
+	}
+}
+
+// snapshot captures the current state, including the restored
+// states of the targets not added yet
+func (c *Checker) snapshot() *Snapshot {
+	s := &Snapshot{
+		Time:     time.Now(),
+		Targets:  make(map[string]TargetState, len(c.targets)+len(c.restored)),
+		Silences: make([]Silence, 0, len(c.silences)),
+	}
+	for name, state := range c.restored {
+		s.Targets[name] = state
+	}
+	for name, t := range c.targets {
+		if t.state == 0 {
+			continue
+		}
+		state := TargetState{
+			State:     t.state,
+			Healthy:   t.healthy,
+			Settled:   t.settled,
+			LastCheck: t.lastReport.ts,
+		}
+		if t.lastReport.err != nil {
+			state.Error = t.lastReport.err.Error()
+			state.ErrorClass = t.lastReport.err.Class
+		}
+		s.Targets[name] = state
+	}
+	for id, silence := range c.silences {
+		if silence.configured || strings.HasPrefix(id, annotationSilencePrefix) {
+			continue
+		}
+		s.Silences = append(s.Silences, *silence)
+	}
+	return s
+}
+
+// persist saves the snapshots queued by the run loop.
+// Only the most recent snapshot is kept when saving is slow.
+func (c *Checker) persist() {
+	for s := range c.snapshots {
+		if err := c.Store.Save(s); err != nil {
+			c.slogger.Errorf("Error saving the state snapshot: %v", err)
+		}
+	}
+	close(c.persisted)
+}
+
+// queueSnapshot hands the current state over to the persisting goroutine
+func (c *Checker) queueSnapshot() {
+	s := c.snapshot()
+	select {
+	case c.snapshots <- s:
+	default:
+		select {
+		case <-c.snapshots:
+		default:
+		}
+		c.snapshots <- s
+	}
+}
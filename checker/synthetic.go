@@ -0,0 +1,431 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStepBody is the maximum size of a step response body read for the extractions and the assertions
+const maxStepBody = 1 << 20
+
+// variablePattern matches the ${name} references to the variables extracted by the previous steps
+var variablePattern = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+// SyntheticCheck declares a target checked by a sequence of HTTP steps, e.g. a login
+// followed by an API call. The whole sequence counts as a single service.
+type SyntheticCheck struct {
+	Name      string `json:"name"`                // Unique target name
+	Namespace string `json:"namespace,omitempty"` // Namespace of the service, if any
+	URL       string `json:"url,omitempty"`       // Base URL of the relative step URLs, if any
+	Steps     []Step `json:"steps"`
+}
+
+// Target validates the synthetic check and describes its target
+func (s *SyntheticCheck) Target() (TargetSpec, error) {
+	if s.Name == "" {
+		return TargetSpec{}, fmt.Errorf("synthetic check must define the name")
+	}
+	if err := validateSteps(s.URL, s.Steps); err != nil {
+		return TargetSpec{}, fmt.Errorf("synthetic check %s: %v", s.Name, err)
+	}
+	return TargetSpec{Name: s.Name, URL: s.URL, Namespace: s.Namespace, Steps: s.Steps}, nil
+}
+
+// Redacted describes the synthetic check without revealing the secret values.
+// The request bodies and the values of the headers with sensitive names are redacted.
+func (s SyntheticCheck) Redacted() SyntheticCheck {
+	steps := make([]Step, len(s.Steps))
+	for i, step := range s.Steps {
+		if len(step.Headers) > 0 {
+			headers := make(map[string]string, len(step.Headers))
+			for name, value := range step.Headers {
+				headers[name] = value
+				if sensitive(name) {
+					headers[name] = redacted
+				}
+			}
+			step.Headers = headers
+		}
+		if step.Body != "" {
+			step.Body = redacted
+		}
+		steps[i] = step
+	}
+	s.Steps = steps
+	return s
+}
+
+// Step is a single HTTP request of a synthetic check.
+// The URL, the header values, the body and the expected values may refer to
+// the variables extracted by the previous steps as ${name}.
+type Step struct {
+	Name    string            `json:"name,omitempty"`    // Step name in the reports, defaults to the step number
+	Method  string            `json:"method,omitempty"`  // Request method, defaults to GET
+	URL     string            `json:"url,omitempty"`     // Absolute or relative to the target URL, defaults to the target URL
+	Headers map[string]string `json:"headers,omitempty"` // Added to the headers and the credentials of the target
+	Body    string            `json:"body,omitempty"`    // Request body, if any
+	Status  []int             `json:"status,omitempty"`  // Accepted status codes, defaults to any 2xx
+	Extract []Extraction      `json:"extract,omitempty"` // Values extracted into the variables
+	Assert  []Assertion       `json:"assert,omitempty"`  // Conditions on the response
+}
+
+// Extraction stores a value of the step response into a variable.
+// The value is read from a header or with a JSON path from the body, e.g. "$.items[0].id".
+type Extraction struct {
+	Var      string `json:"var"`
+	JSONPath string `json:"jsonPath,omitempty"`
+	Header   string `json:"header,omitempty"`
+}
+
+// Assertion checks a value of the step response: a header, a value of the JSON body
+// or, without both, the whole body. Without Equals and Contains, the value must exist.
+type Assertion struct {
+	JSONPath string `json:"jsonPath,omitempty"`
+	Header   string `json:"header,omitempty"`
+	Equals   string `json:"equals,omitempty"`
+	Contains string `json:"contains,omitempty"`
+}
+
+// StepResult reports the last run of a step of a synthetic check
+type StepResult struct {
+	Name       string     `json:"name"`
+	Status     int        `json:"status,omitempty"`     // Response status code, if any
+	DurationMs int64      `json:"durationMs"`           // Duration of the request and of the checks
+	Error      string     `json:"error,omitempty"`      // Error of the step, if failed
+	ErrorClass ErrorClass `json:"errorClass,omitempty"` // Class of the error of the step
+}
+
+// name returns the step name or its number
+func (s *Step) name(i int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return strconv.Itoa(i + 1)
+}
+
+// accepts reports whether the step accepts the response status code
+func (s *Step) accepts(code int) bool {
+	if len(s.Status) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, status := range s.Status {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSteps checks the steps, and that each of them only refers to
+// the variables extracted by the previous ones
+func validateSteps(base string, steps []Step) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+
+	defined := make(map[string]bool)
+	for i := range steps {
+		step := &steps[i]
+		if i == 0 {
+			u, err := url.Parse(base)
+			if err == nil {
+				u, err = u.Parse(step.URL)
+			}
+			if err != nil || !u.IsAbs() {
+				return fmt.Errorf("step %s: the first step needs an absolute URL", step.name(i))
+			}
+		}
+
+		refs := []string{step.URL, step.Body}
+		for _, value := range step.Headers {
+			refs = append(refs, value)
+		}
+		for _, a := range step.Assert {
+			if a.JSONPath != "" && a.Header != "" {
+				return fmt.Errorf("step %s: assertion can't check both a header and a JSON path", step.name(i))
+			}
+			if _, err := parseJSONPath(a.JSONPath); err != nil {
+				return fmt.Errorf("step %s: %v", step.name(i), err)
+			}
+			refs = append(refs, a.Equals, a.Contains)
+		}
+		for _, ref := range refs {
+			for _, match := range variablePattern.FindAllStringSubmatch(ref, -1) {
+				if !defined[match[1]] {
+					return fmt.Errorf("step %s: variable %s is not extracted by a previous step", step.name(i), match[1])
+				}
+			}
+		}
+
+		for _, e := range step.Extract {
+			if e.Var == "" {
+				return fmt.Errorf("step %s: extraction must define the variable", step.name(i))
+			}
+			if (e.JSONPath == "") == (e.Header == "") {
+				return fmt.Errorf("step %s: variable %s needs either a header or a JSON path", step.name(i), e.Var)
+			}
+			if _, err := parseJSONPath(e.JSONPath); err != nil {
+				return fmt.Errorf("step %s: %v", step.name(i), err)
+			}
+			defined[e.Var] = true
+		}
+	}
+	return nil
+}
+
+// first returns the first non-empty value
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// expand replaces the variable references with their values
+func expand(s string, vars map[string]string) string {
+	return variablePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		return vars[ref[2:len(ref)-1]]
+	})
+}
+
+// runSteps runs the steps of the synthetic check of the target in order, starting
+// each one from the base request. The headers and the credentials of the target are
+// only sent to its host. It stops at the first failed step and returns the last response.
+func (c *Checker) runSteps(ctx context.Context, client *http.Client, base *http.Request, t *target, r *report) (*http.Response, error) {
+	vars := make(map[string]string)
+	var last *http.Response
+	for i := range t.steps {
+		step := &t.steps[i]
+		start := time.Now()
+		resp, err := runStep(ctx, client, base, step, vars, t.stepsLocal)
+		result := StepResult{Name: step.name(i), DurationMs: time.Since(start).Milliseconds()}
+		if resp != nil {
+			result.Status = resp.StatusCode
+			last = resp
+		}
+		if err != nil {
+			checkErr := classify(err)
+			result.Error = checkErr.Error()
+			result.ErrorClass = checkErr.Class
+			r.steps = append(r.steps, result)
+			return nil, &CheckError{
+				Class:      checkErr.Class,
+				StatusCode: checkErr.StatusCode,
+				Err:        fmt.Errorf("step %s: %v", result.Name, checkErr.Err),
+			}
+		}
+		r.steps = append(r.steps, result)
+	}
+	return last, nil
+}
+
+// runStep sends the request of the step, checks the response and extracts its variables.
+// If local, the step fails when its URL leaves the host of the base request.
+func runStep(ctx context.Context, client *http.Client, base *http.Request, step *Step, vars map[string]string, local bool) (*http.Response, error) {
+	target, err := base.URL.Parse(expand(step.URL, vars))
+	if err != nil {
+		return nil, err
+	}
+	sameHost := target.Host == base.URL.Host
+	if local && !sameHost {
+		return nil, &CheckError{Class: ErrorOther, Err: fmt.Errorf("step URL outside of the target host %s", base.URL.Host)}
+	}
+	var body io.Reader
+	if step.Body != "" {
+		body = strings.NewReader(expand(step.Body, vars))
+	}
+	req, err := http.NewRequestWithContext(ctx, first(step.Method, http.MethodGet), target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if sameHost {
+		req.Header = base.Header.Clone()
+		req.Host = base.Host
+	}
+	for name, value := range step.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = expand(value, vars)
+			continue
+		}
+		req.Header.Set(name, expand(value, vars))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxStepBody))
+	if err != nil {
+		return resp, err
+	}
+	if !step.accepts(resp.StatusCode) {
+		return resp, statusError(resp.StatusCode)
+	}
+
+	values := &stepValues{resp: resp, body: data}
+	for _, a := range step.Assert {
+		if err := a.check(values, vars); err != nil {
+			return resp, &CheckError{Class: ErrorAssertion, Err: err}
+		}
+	}
+	for _, e := range step.Extract {
+		value, ok, err := values.get(e.Header, e.JSONPath)
+		if err != nil {
+			return resp, &CheckError{Class: ErrorAssertion, Err: err}
+		}
+		if !ok {
+			return resp, &CheckError{Class: ErrorAssertion, Err: fmt.Errorf("no value for variable %s", e.Var)}
+		}
+		vars[e.Var] = value
+	}
+	return resp, nil
+}
+
+// stepValues reads the values of a step response, decoding the JSON body at most once
+type stepValues struct {
+	resp    *http.Response
+	body    []byte
+	doc     interface{}
+	decoded bool
+}
+
+// get returns the value of the header, of the JSON path or the whole body,
+// and whether it exists
+func (v *stepValues) get(header, path string) (string, bool, error) {
+	switch {
+	case header != "":
+		values, ok := v.resp.Header[http.CanonicalHeaderKey(header)]
+		if !ok {
+			return "", false, nil
+		}
+		return values[0], true, nil
+	case path != "":
+		if !v.decoded {
+			decoder := json.NewDecoder(bytes.NewReader(v.body))
+			decoder.UseNumber()
+			if err := decoder.Decode(&v.doc); err != nil {
+				return "", false, fmt.Errorf("invalid JSON body: %v", err)
+			}
+			v.decoded = true
+		}
+		return lookupJSON(v.doc, path)
+	default:
+		return string(v.body), true, nil
+	}
+}
+
+// check verifies the assertion on the step response. The errors only quote the expected
+// values before the expansion of the variables, since the response values may be secrets.
+func (a *Assertion) check(values *stepValues, vars map[string]string) error {
+	subject := "body"
+	switch {
+	case a.Header != "":
+		subject = "header " + a.Header
+	case a.JSONPath != "":
+		subject = a.JSONPath
+	}
+
+	value, ok, err := values.get(a.Header, a.JSONPath)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return fmt.Errorf("%s not found", subject)
+	case a.Equals != "" && value != expand(a.Equals, vars):
+		return fmt.Errorf("%s doesn't equal %q", subject, a.Equals)
+	case a.Contains != "" && !strings.Contains(value, expand(a.Contains, vars)):
+		return fmt.Errorf("%s doesn't contain %q", subject, a.Contains)
+	}
+	return nil
+}
+
+// parseJSONPath splits the JSON path into the object keys and the array indexes.
+// Only the dot and the index notations are supported, e.g. "$.items[0].id".
+func parseJSONPath(path string) ([]interface{}, error) {
+	var tokens []interface{}
+	rest := strings.TrimPrefix(path, "$")
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSON path %q", path)
+			}
+			tokens = append(tokens, rest[:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index in JSON path %q", path)
+			}
+			tokens = append(tokens, index)
+			rest = rest[end+1:]
+		default:
+			if len(tokens) > 0 || path[0] == '$' {
+				return nil, fmt.Errorf("invalid JSON path %q", path)
+			}
+			rest = "." + rest
+		}
+	}
+	return tokens, nil
+}
+
+// lookupJSON returns the value at the JSON path of the decoded document as a string,
+// and whether it exists. The objects and the arrays are returned as JSON.
+func lookupJSON(doc interface{}, path string) (string, bool, error) {
+	tokens, err := parseJSONPath(path)
+	if err != nil {
+		return "", false, err
+	}
+
+	value := doc
+	for _, token := range tokens {
+		switch token := token.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return "", false, nil
+			}
+			if value, ok = object[token]; !ok {
+				return "", false, nil
+			}
+		case int:
+			array, ok := value.([]interface{})
+			if !ok || token >= len(array) {
+				return "", false, nil
+			}
+			value = array[token]
+		}
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value, true, nil
+	case json.Number:
+		return value.String(), true, nil
+	default:
+		data, err := json.Marshal(value)
+		return string(data), true, err
+	}
+}
@@ -0,0 +1,154 @@
+package checker
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// tlsReloadInterval is the time between two loads of the TLS material of a target,
+// so that the rotated certificates are picked up
+const tlsReloadInterval = time.Minute
+
+// TLSConfig holds the TLS settings of the checks of a target
+type TLSConfig struct {
+	ServerName         string    // Overrides the server name verified in the target certificate
+	InsecureSkipVerify bool      // Skips the verification of the target certificate
+	Source             TLSSource // Provides the CA bundle and the client certificate, if set
+}
+
+// TLSSource provides the TLS material of a target, e.g. from files or a Kubernetes Secret.
+// It is loaded again periodically to pick up the rotated certificates.
+type TLSSource interface {
+	Load() (*TLSMaterial, error)
+}
+
+// TLSMaterial is the PEM encoded TLS material of a target.
+// All the parts are optional, but the client certificate requires its key.
+type TLSMaterial struct {
+	CA   []byte // CA bundle trusted to verify the target certificate instead of the system roots
+	Cert []byte // Client certificate
+	Key  []byte // Client certificate key
+}
+
+// equal reports whether the material is the same as the other one
+func (m *TLSMaterial) equal(other *TLSMaterial) bool {
+	return other != nil &&
+		bytes.Equal(m.CA, other.CA) &&
+		bytes.Equal(m.Cert, other.Cert) &&
+		bytes.Equal(m.Key, other.Key)
+}
+
+// FileTLSSource reads the TLS material from PEM files, e.g. the ones of the service settings
+type FileTLSSource struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// Load reads the configured files
+func (s *FileTLSSource) Load() (*TLSMaterial, error) {
+	var m TLSMaterial
+	for _, part := range []struct {
+		path string
+		data *[]byte
+	}{
+		{s.CAFile, &m.CA},
+		{s.CertFile, &m.Cert},
+		{s.KeyFile, &m.Key},
+	} {
+		if part.path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(part.path)
+		if err != nil {
+			return nil, err
+		}
+		*part.data = data
+	}
+	return &m, nil
+}
+
+// build creates the Go TLS configuration from the settings and the material
+func (c *TLSConfig) build(m *TLSMaterial) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if m == nil {
+		return config, nil
+	}
+
+	if len(m.CA) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(m.CA) {
+			return nil, errors.New("no valid certificate in the CA bundle")
+		}
+	}
+
+	if len(m.Cert) > 0 || len(m.Key) > 0 {
+		cert, err := tls.X509KeyPair(m.Cert, m.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// targetClient is the HTTP client of the checks of a single target.
+type targetClient struct {
+	tls      *TLSConfig
+	client   *http.Client
+	material *TLSMaterial
+	loadedAt time.Time
+}
+
+// get returns the client, rebuilding it when the TLS material has changed.
+// If the material can't be reloaded, the previous client is kept.
+func (tc *targetClient) get(now time.Time) (*http.Client, error) {
+	if tc.client != nil && (tc.tls == nil || now.Sub(tc.loadedAt) < tlsReloadInterval) {
+		return tc.client, nil
+	}
+	tc.loadedAt = now
+
+	var material *TLSMaterial
+	if tc.tls != nil && tc.tls.Source != nil {
+		var err error
+		if material, err = tc.tls.Source.Load(); err != nil {
+			if tc.client != nil {
+				return tc.client, fmt.Errorf("reloading TLS material: %v", err)
+			}
+			return nil, fmt.Errorf("loading TLS material: %v", err)
+		}
+		if tc.client != nil && material.equal(tc.material) {
+			return tc.client, nil
+		}
+	} else if tc.client != nil {
+		return tc.client, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tc.tls != nil {
+		config, err := tc.tls.build(material)
+		if err != nil {
+			if tc.client != nil {
+				return tc.client, err
+			}
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+
+	if tc.client != nil {
+		tc.client.CloseIdleConnections()
+	}
+	tc.client = &http.Client{Transport: transport}
+	tc.material = material
+	return tc.client, nil
+}
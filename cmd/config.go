@@ -3,11 +3,15 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"reflect"
 	"strings"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"wiley.com/healthcat/checker"
 )
 
 const (
@@ -15,8 +19,10 @@ const (
 	configEnvPrefix = "HEALTHCAT"
 )
 
-// LoadConfig loads system parameters from a config file and from enviroment variables if they are defined
-func LoadConfig(cmd *cobra.Command, filePath string, fileName string) error {
+// LoadConfig loads system parameters from a config file and from enviroment variables if they are defined.
+// The structured sections of the config file, which have no corresponding flags,
+// are decoded into the values of the sections map keyed by the section name.
+func LoadConfig(cmd *cobra.Command, filePath string, fileName string, sections map[string]interface{}) error {
 	v := viper.New()
 
 	configName := fileName
@@ -45,6 +51,18 @@ func LoadConfig(cmd *cobra.Command, filePath string, fileName string) error {
 
 	bindFlags(cmd, v)
 
+	decodeHook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		stringToValueRefHookFunc(),
+	))
+	for key, val := range sections {
+		if err := v.UnmarshalKey(key, val, decodeHook); err != nil {
+			return fmt.Errorf("invalid %q configuration: %v", key, err)
+		}
+	}
+
 	return nil
 }
 
@@ -56,3 +74,33 @@ func bindFlags(cmd *cobra.Command, v *viper.Viper) {
 		}
 	})
 }
+
+// configDump lists the effective value of each flag, once the config file and the environment
+// variables are applied, along with the structured sections of the config file
+func configDump(flags *pflag.FlagSet, sections map[string]interface{}) map[string]interface{} {
+	dump := make(map[string]interface{})
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			dump[f.Name] = slice.GetSlice()
+		} else {
+			dump[f.Name] = f.Value.String()
+		}
+	})
+	for key, val := range sections {
+		dump[key] = val
+	}
+	return dump
+}
+
+// stringToValueRefHookFunc accepts a plain string as a literal request value
+func stringToValueRefHookFunc() mapstructure.DecodeHookFuncType {
+	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+		if from.Kind() != reflect.String || to != reflect.TypeOf(checker.ValueRef{}) {
+			return data, nil
+		}
+		return checker.ValueRef{Value: data.(string)}, nil
+	}
+}
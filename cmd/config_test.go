@@ -5,6 +5,9 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
+
+	"wiley.com/healthcat/checker"
 )
 
 var yamlConfigFile = []byte(`
@@ -18,6 +21,49 @@ failed-hc-cnt: 6
 status-threshold: 200
 port: 8980
 log-preset: prod
+count-impacted: true
+tls-require-client-cert: true
+silences:
+  - namespace: payments
+    endsAt: 2030-01-02T15:04:05Z
+    createdBy: ops
+    comment: database migration
+expected-services:
+  - name: auth.platform
+  - namespace: ingress
+    selector: app=gateway
+dependencies:
+  - service: orders.shop
+    dependsOn: [auth.platform, db.shop]
+synthetic-checks:
+  - name: checkout.shop
+    namespace: shop
+    url: http://checkout.shop:8080
+    steps:
+      - name: login
+        method: POST
+        url: /login
+        body: '{"user": "health"}'
+        status: [200, 201]
+        extract:
+          - var: token
+            jsonPath: $.token
+      - url: /cart
+        headers:
+          Authorization: Bearer ${token}
+        assert:
+          - jsonPath: $.items
+            equals: "[]"
+service-settings:
+  - service: billing.shop
+    request:
+      headers:
+        Host: billing.example.com
+        X-Health-Key: {env: BILLING_HEALTH_KEY}
+      bearerToken: {file: /var/run/secrets/billing/token}
+    tls:
+      caFile: /var/run/secrets/billing/ca.crt
+      serverName: billing.example.com
 `)
 
 type testCase struct {
@@ -122,6 +168,109 @@ func TestLoadConfigPrecedenceOrder(t *testing.T) {
 				return cmdArgs.logPreset
 			},
 		},
+		{
+			name:         "count-impacted",
+			configSource: "file",
+			value:        "true",
+			want:         true,
+			got: func() interface{} {
+				return cmdArgs.countImpacted
+			},
+		},
+		{
+			name:         "tls-require-client-cert",
+			configSource: "file",
+			value:        "true",
+			want:         true,
+			got: func() interface{} {
+				return cmdArgs.tlsRequireClient
+			},
+		},
+		{
+			name:         "silences",
+			configSource: "file",
+			want: []checker.Silence{
+				{
+					Namespace: "payments",
+					EndsAt:    time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC),
+					CreatedBy: "ops",
+					Comment:   "database migration",
+				},
+			},
+			got: func() interface{} {
+				return cmdArgs.silences
+			},
+		},
+		{
+			name:         "expected-services",
+			configSource: "file",
+			want: []checker.Expectation{
+				{Name: "auth.platform"},
+				{Namespace: "ingress", Selector: "app=gateway"},
+			},
+			got: func() interface{} {
+				return cmdArgs.expected
+			},
+		},
+		{
+			name:         "dependencies",
+			configSource: "file",
+			want: []checker.Dependency{
+				{Service: "orders.shop", DependsOn: []string{"auth.platform", "db.shop"}},
+			},
+			got: func() interface{} {
+				return cmdArgs.dependencies
+			},
+		},
+		{
+			name:         "synthetic-checks",
+			configSource: "file",
+			want: []checker.SyntheticCheck{
+				{
+					Name:      "checkout.shop",
+					Namespace: "shop",
+					URL:       "http://checkout.shop:8080",
+					Steps: []checker.Step{
+						{
+							Name:    "login",
+							Method:  "POST",
+							URL:     "/login",
+							Body:    `{"user": "health"}`,
+							Status:  []int{200, 201},
+							Extract: []checker.Extraction{{Var: "token", JSONPath: "$.token"}},
+						},
+						{
+							URL:     "/cart",
+							Headers: map[string]string{"Authorization": "Bearer ${token}"},
+							Assert:  []checker.Assertion{{JSONPath: "$.items", Equals: "[]"}},
+						},
+					},
+				},
+			},
+			got: func() interface{} {
+				return cmdArgs.syntheticChecks
+			},
+		},
+		{
+			name:         "service-settings",
+			configSource: "file",
+			want: []checker.ServiceSettings{
+				{
+					Service: "billing.shop",
+					Request: &checker.RequestSettings{
+						Headers: map[string]checker.ValueRef{
+							"Host":         {Value: "billing.example.com"},
+							"X-Health-Key": {Env: "BILLING_HEALTH_KEY"},
+						},
+						BearerToken: &checker.ValueRef{File: "/var/run/secrets/billing/token"},
+					},
+					TLS: &checker.TLSSettings{CAFile: "/var/run/secrets/billing/ca.crt", ServerName: "billing.example.com"},
+				},
+			},
+			got: func() interface{} {
+				return cmdArgs.serviceSettings
+			},
+		},
 	}
 
 	envVariableTestCases := []testCase{
@@ -197,6 +346,15 @@ func TestLoadConfigPrecedenceOrder(t *testing.T) {
 				return cmdArgs.threshold
 			},
 		},
+		{
+			name:         "HEALTHCAT_TLS_MIN_VERSION",
+			configSource: "env",
+			value:        "1.3",
+			want:         "1.3",
+			got: func() interface{} {
+				return cmdArgs.tlsMinVersion
+			},
+		},
 		{
 			name:         "HEALTHCAT_PORT",
 			configSource: "env",
@@ -410,3 +568,27 @@ func runTests(cmdArgs *mainCmdArgs, flags []string, testCases []testCase, t *tes
 		}
 	}
 }
+
+func TestConfigDump(t *testing.T) {
+	cmdArgs := &mainCmdArgs{}
+	cmd := newMainCmd(cmdArgs)
+	flags := cmd.Flags()
+	if err := flags.Parse([]string{"--cluster-id", "c1", "--namespaces", "shop,platform"}); err != nil {
+		t.Fatal(err)
+	}
+	cmdArgs.silences = []checker.Silence{{Namespace: "payments"}}
+
+	dump := configDump(flags, cmdArgs.sections())
+	if got, want := dump["cluster-id"], "c1"; got != want {
+		t.Errorf("got cluster-id %v, want %v", got, want)
+	}
+	if got, want := dump["namespaces"], []string{"shop", "platform"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got namespaces %v, want %v", got, want)
+	}
+	if got, want := dump["port"], "8080"; got != want {
+		t.Errorf("got port %v, want the default %v", got, want)
+	}
+	if got, ok := dump["silences"].(*[]checker.Silence); !ok || !reflect.DeepEqual(*got, cmdArgs.silences) {
+		t.Errorf("got silences %v, want %v", dump["silences"], cmdArgs.silences)
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -24,6 +25,21 @@ const (
 	defaultPort       = 8080
 	defaultLogPreset  = "dev"
 	defaultConfigFile = "./config/config.yml"
+	defaultStateStore = "none"
+	defaultStateFile  = "./healthcat-state.json"
+	defaultStateCM    = "healthcat/healthcat-state"
+	defaultSnapshots  = "30s"
+	defaultNotReady   = 552
+	defaultFailure    = 500
+	defaultMinTargets = 0
+	defaultEmpty      = "healthy"
+	defaultCertWarn   = "720h"
+	defaultCertCrit   = "0s"
+	defaultFlapWindow = 20
+	defaultFlapHigh   = 50
+	defaultFlapLow    = 25
+	defaultRetry      = "0s"
+	defaultTLSMin     = "1.2"
 )
 
 type mainCmdArgs struct {
@@ -35,9 +51,54 @@ type mainCmdArgs struct {
 	nsuccess           int
 	nfailure           int
 	threshold          int
+	minTargets         int
+	emptyPolicy        string
 	port               int
 	logPreset          string
 	configFile         string
+	stateStore         string
+	stateFile          string
+	stateConfigMap     string
+	snapshotInterval   time.Duration
+	notReadyStatus     int
+	failureStatus      int
+	expectedConfigMap  string
+	certWarning        time.Duration
+	certCritical       time.Duration
+	flapWindow         int
+	flapHigh           int
+	flapLow            int
+	retryInterval      time.Duration
+	countImpacted      bool
+	discoveryPaths     []string
+	discoveryPorts     []int
+	authTokenFile      string
+	authTokenReview    bool
+	authClientCerts    bool
+	authAdmins         []string
+	tlsCert            string
+	tlsKey             string
+	tlsClientCA        string
+	tlsRequireClient   bool
+	tlsMinVersion      string
+	adminHost          string
+	adminPort          int
+	dependencies       []checker.Dependency
+	expected           []checker.Expectation
+	silences           []checker.Silence
+	syntheticChecks    []checker.SyntheticCheck
+	serviceSettings    []checker.ServiceSettings
+}
+
+// sections are the structured sections of the config file, which have no corresponding flags
+func (mainArgs *mainCmdArgs) sections() map[string]interface{} {
+	return map[string]interface{}{
+		"silences":          &mainArgs.silences,
+		"expected-services": &mainArgs.expected,
+		"dependencies":      &mainArgs.dependencies,
+		"synthetic-checks":  &mainArgs.syntheticChecks,
+		"service-settings":  &mainArgs.serviceSettings,
+	}
 }
 
 func newMainCmd(mainArgs *mainCmdArgs) *cobra.Command {
@@ -70,12 +131,23 @@ cluster identifier that will be included in all healthcat reports.`,
 				fileName := filepath.Base(abs)
 				fileLocation := filepath.Dir(abs)
 
-				return LoadConfig(cmd, fileLocation, fileName)
+				return LoadConfig(cmd, fileLocation, fileName, mainArgs.sections())
 			}
 			return nil
 		},
-		RunE: func(*cobra.Command, []string) error {
-			return runServer(mainArgs)
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			sections := mainArgs.sections()
+			syntheticChecks := make([]checker.SyntheticCheck, len(mainArgs.syntheticChecks))
+			for i, check := range mainArgs.syntheticChecks {
+				syntheticChecks[i] = check.Redacted()
+			}
+			sections["synthetic-checks"] = syntheticChecks
+			serviceSettings := make([]checker.ServiceSettings, len(mainArgs.serviceSettings))
+			for i, settings := range mainArgs.serviceSettings {
+				serviceSettings[i] = settings.Redacted()
+			}
+			sections["service-settings"] = serviceSettings
+			return runServer(mainArgs, configDump(cmd.Flags(), sections))
 		},
 	}
 
@@ -90,8 +162,40 @@ cluster identifier that will be included in all healthcat reports.`,
 	flags.IntVarP(&mainArgs.nsuccess, "successful-hc-cnt", "s", defaultNSuccess, "number of successful consecutive health checks counts")
 	flags.IntVarP(&mainArgs.nfailure, "failed-hc-cnt", "F", defaultNFailure, "number of failed consecutive health checks counts")
 	flags.IntVarP(&mainArgs.threshold, "status-threshold", "P", defaultThreshold, "percentage of successful health checks to set cluster status OK")
+	flags.IntVar(&mainArgs.minTargets, "min-targets", defaultMinTargets, "minimum number of monitored services to set cluster status OK")
+	flags.StringVar(&mainArgs.emptyPolicy, "empty-policy", defaultEmpty, "cluster status without monitored services (healthy|unhealthy|unknown)")
 	flags.StringVar(&mainArgs.logPreset, "log-preset", defaultLogPreset, "Log preset config (dev|prod)")
 	flags.StringVarP(&mainArgs.configFile, "config", "f", defaultConfigFile, "/path/to/config.yml")
+	flags.StringVar(&mainArgs.stateStore, "state-store", defaultStateStore, "store persisting the state across restarts (none|file|configmap)")
+	flags.StringVar(&mainArgs.stateFile, "state-file", defaultStateFile, "path to the state file of the file store")
+	flags.StringVar(&mainArgs.stateConfigMap, "state-configmap", defaultStateCM, "namespace/name of the ConfigMap of the configmap store")
+	flags.DurationVar(&mainArgs.snapshotInterval, "snapshot-interval", duration(defaultSnapshots), "time between two consecutive state snapshots")
+	flags.StringVar(&mainArgs.expectedConfigMap, "expected-configmap", "", "namespace/name of the ConfigMap listing the expected services")
+	flags.DurationVar(&mainArgs.certWarning, "cert-warning", duration(defaultCertWarn), "services with a certificate expiring within the window are degraded")
+	flags.DurationVar(&mainArgs.certCritical, "cert-critical", duration(defaultCertCrit), "services with a certificate expiring within the window fail")
+	flags.DurationVar(&mainArgs.retryInterval, "retry-interval", duration(defaultRetry), "time between two health checks of a service changing its state, 0 to disable")
+	flags.IntVar(&mainArgs.flapWindow, "flap-window", defaultFlapWindow, "number of health checks of the flap detection, 0 to disable it")
+	flags.IntVar(&mainArgs.flapHigh, "flap-high-threshold", defaultFlapHigh, "percentage of state changes above which a service is flapping")
+	flags.IntVar(&mainArgs.flapLow, "flap-low-threshold", defaultFlapLow, "percentage of state changes below which a service stops flapping")
+	flags.BoolVar(&mainArgs.countImpacted, "count-impacted", false, "count the services impacted by a failed upstream service as failed")
+	flags.StringSliceVar(&mainArgs.discoveryPaths, "discovery-paths", []string{},
+		"candidate health paths of the services without the chc/path annotation, tried in order")
+	flags.IntSliceVar(&mainArgs.discoveryPorts, "discovery-ports", []int{}, "candidate ports of the health path discovery, defaults to the service ports")
+	flags.IntVar(&mainArgs.notReadyStatus, "not-ready-status", defaultNotReady, "HTTP status code reported by /healthz/ready when not ready")
+	flags.IntVar(&mainArgs.failureStatus, "failure-status", defaultFailure, "HTTP status code reported by /status when the cluster is unhealthy")
+	flags.StringVar(&mainArgs.authTokenFile, "auth-token-file", "", "file of the static bearer tokens allowed to call the API, as token,name,role lines")
+	flags.BoolVar(&mainArgs.authTokenReview, "auth-token-review", false, "authenticate the bearer tokens with the Kubernetes TokenReview API")
+	flags.BoolVar(&mainArgs.authClientCerts, "auth-client-certs", false, "authenticate the verified TLS client certificates")
+	flags.StringSliceVar(&mainArgs.authAdmins, "auth-admins", []string{},
+		"users, groups or certificate names granted the admin role by the token review and client certificate authentication")
+	flags.StringVar(&mainArgs.tlsCert, "tls-cert", "", "path to the server certificate, serves the API over TLS if set")
+	flags.StringVar(&mainArgs.tlsKey, "tls-key", "", "path to the server certificate key")
+	flags.StringVar(&mainArgs.tlsClientCA, "tls-client-ca", "", "path to the CA bundle verifying the client certificates")
+	flags.BoolVar(&mainArgs.tlsRequireClient, "tls-require-client-cert", false, "reject the clients without a certificate verified by the client CA")
+	flags.StringVar(&mainArgs.tlsMinVersion, "tls-min-version", defaultTLSMin, "minimum TLS version (1.0|1.1|1.2|1.3)")
+	flags.StringVar(&mainArgs.adminHost, "admin-listen-address", defaultAddress, "bind address of the admin listener")
+	flags.IntVar(&mainArgs.adminPort, "admin-port", 0,
+		"bind port of the admin listener serving the mutating, metrics and debug routes, the main port then serving only the read-only routes; 0 to disable")
 
 	rootCmd.MarkFlagRequired("cluster-id")
 
@@ -104,12 +208,25 @@ func Execute() error {
 	return cmd.Execute()
 }
 
-func runServer(cmdArgs *mainCmdArgs) error {
+func runServer(cmdArgs *mainCmdArgs, config map[string]interface{}) error {
+	if err := validateStatusCodes(cmdArgs); err != nil {
+		return err
+	}
+
 	var host string
 	if cmdArgs.host != "*" {
 		host = cmdArgs.host
 	}
 
+	var adminAddress string
+	if cmdArgs.adminPort != 0 {
+		var adminHost string
+		if cmdArgs.adminHost != "*" {
+			adminHost = cmdArgs.adminHost
+		}
+		adminAddress = fmt.Sprintf("%s:%d", adminHost, cmdArgs.adminPort)
+	}
+
 	var log *zap.Logger
 	var errLog error
 
@@ -129,37 +246,201 @@ func runServer(cmdArgs *mainCmdArgs) error {
 
 	defer log.Sync()
 
+	store, err := newStateStore(cmdArgs)
+	if err != nil {
+		return err
+	}
+
+	synthetic := make([]checker.TargetSpec, 0, len(cmdArgs.syntheticChecks))
+	for i := range cmdArgs.syntheticChecks {
+		spec, err := cmdArgs.syntheticChecks[i].Target()
+		if err != nil {
+			return err
+		}
+		synthetic = append(synthetic, spec)
+	}
+
 	checker := &checker.Checker{
-		ClusterID:        cmdArgs.clusterID,
-		Interval:         cmdArgs.interval,
-		FailureThreshold: cmdArgs.nfailure,
-		SuccessThreshold: cmdArgs.nsuccess,
-		StateThreshold:   cmdArgs.threshold,
-		Logger:           log,
+		ClusterID:         cmdArgs.clusterID,
+		Interval:          cmdArgs.interval,
+		FailureThreshold:  cmdArgs.nfailure,
+		SuccessThreshold:  cmdArgs.nsuccess,
+		StateThreshold:    cmdArgs.threshold,
+		MinTargets:        cmdArgs.minTargets,
+		EmptyPolicy:       checker.ClusterStatus(cmdArgs.emptyPolicy),
+		Silences:          cmdArgs.silences,
+		Expected:          cmdArgs.expected,
+		Dependencies:      cmdArgs.dependencies,
+		Settings:          cmdArgs.serviceSettings,
+		CountImpacted:     cmdArgs.countImpacted,
+		Store:             store,
+		SnapshotInterval:  cmdArgs.snapshotInterval,
+		CertWarning:       cmdArgs.certWarning,
+		CertCritical:      cmdArgs.certCritical,
+		FlapWindow:        cmdArgs.flapWindow,
+		FlapHighThreshold: cmdArgs.flapHigh,
+		FlapLowThreshold:  cmdArgs.flapLow,
+		RetryInterval:     cmdArgs.retryInterval,
+		Logger:            log,
 	}
 	if err := checker.Run(); err != nil {
 		return err
 	}
+	for _, spec := range synthetic {
+		checker.AddTarget(spec)
+	}
 
 	eventSource := &k8s.EventSource{
 		Logger:             log,
 		Namespaces:         cmdArgs.namespaces,
 		ExcludedNamespaces: cmdArgs.excludedNamespaces,
 		Registry:           checker,
+		DiscoveryPaths:     cmdArgs.discoveryPaths,
+		DiscoveryPorts:     cmdArgs.discoveryPorts,
 	}
 	if err := eventSource.Start(); err != nil {
 		return err
 	}
 
+	namespaceSource := &k8s.NamespaceSource{
+		Logger:   log,
+		Registry: checker,
+	}
+	if err := namespaceSource.Start(); err != nil {
+		return err
+	}
+
+	if cmdArgs.expectedConfigMap != "" {
+		namespace, name, err := splitNamespacedName(cmdArgs.expectedConfigMap)
+		if err != nil {
+			return err
+		}
+		expectedSource := &k8s.ExpectedSource{
+			Logger:    log,
+			Namespace: namespace,
+			Name:      name,
+			Registry:  checker,
+		}
+		if err := expectedSource.Start(); err != nil {
+			return err
+		}
+	}
+
+	authenticators, err := newAuthenticators(cmdArgs)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := newTLSConfig(cmdArgs, log)
+	if err != nil {
+		return err
+	}
+
 	server := &server.Server{
-		Address: fmt.Sprintf("%s:%d", host, cmdArgs.port),
-		Checker: checker,
-		Logger:  log,
+		Address:        fmt.Sprintf("%s:%d", host, cmdArgs.port),
+		Checker:        checker,
+		Logger:         log,
+		NotReadyStatus: cmdArgs.notReadyStatus,
+		FailureStatus:  cmdArgs.failureStatus,
+		Authenticators: authenticators,
+		TLS:            tlsConfig,
+		AdminAddress:   adminAddress,
+		Config:         config,
 	}
 	server.Run()
 	return nil
 }
 
+// validateStatusCodes checks that the failure and not ready status codes are errors,
+// so that the load balancers and the probes can't take them for a success
+func validateStatusCodes(cmdArgs *mainCmdArgs) error {
+	for flag, code := range map[string]int{
+		"--failure-status":   cmdArgs.failureStatus,
+		"--not-ready-status": cmdArgs.notReadyStatus,
+	} {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("invalid %s %d, want a 4xx or 5xx status code", flag, code)
+		}
+	}
+	return nil
+}
+
+func newStateStore(cmdArgs *mainCmdArgs) (checker.StateStore, error) {
+	switch cmdArgs.stateStore {
+	case "none", "":
+		return nil, nil
+	case "file":
+		return &checker.FileStore{Path: cmdArgs.stateFile}, nil
+	case "configmap":
+		namespace, name, err := splitNamespacedName(cmdArgs.stateConfigMap)
+		if err != nil {
+			return nil, err
+		}
+		return k8s.NewConfigMapStore(namespace, name)
+	default:
+		return nil, fmt.Errorf("unsupported state store %q", cmdArgs.stateStore)
+	}
+}
+
+// newAuthenticators creates the authenticators of the mutating API endpoints, tried in order
+func newAuthenticators(cmdArgs *mainCmdArgs) ([]server.Authenticator, error) {
+	var authenticators []server.Authenticator
+	if cmdArgs.authClientCerts {
+		// The certificates are only verified by the TLS server with the client CA
+		if cmdArgs.tlsCert == "" || cmdArgs.tlsClientCA == "" {
+			return nil, fmt.Errorf("--auth-client-certs requires --tls-cert and --tls-client-ca")
+		}
+		authenticators = append(authenticators, &server.CertificateAuthenticator{Admins: cmdArgs.authAdmins})
+	}
+	if cmdArgs.authTokenFile != "" {
+		tokens, err := server.LoadTokenFile(cmdArgs.authTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, tokens)
+	}
+	if cmdArgs.authTokenReview {
+		reviewer, err := k8s.NewTokenReviewer()
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, &server.TokenReviewAuthenticator{Reviewer: reviewer, Admins: cmdArgs.authAdmins})
+	}
+	return authenticators, nil
+}
+
+// newTLSConfig creates the TLS configuration of the API, or none if no certificate is set
+func newTLSConfig(cmdArgs *mainCmdArgs, log *zap.Logger) (*tls.Config, error) {
+	if cmdArgs.tlsCert == "" && cmdArgs.tlsKey == "" {
+		if cmdArgs.tlsClientCA != "" {
+			return nil, fmt.Errorf("a TLS client CA requires a TLS certificate")
+		}
+		return nil, nil
+	}
+
+	minVersion, err := server.ParseTLSVersion(cmdArgs.tlsMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	options := &server.TLSOptions{
+		CertFile:          cmdArgs.tlsCert,
+		KeyFile:           cmdArgs.tlsKey,
+		ClientCAFile:      cmdArgs.tlsClientCA,
+		RequireClientCert: cmdArgs.tlsRequireClient,
+		MinVersion:        minVersion,
+	}
+	return options.Config(log)
+}
+
+// splitNamespacedName splits the namespace/name reference of a Kubernetes object
+func splitNamespacedName(s string) (string, string, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid object reference %q, want namespace/name", s)
+	}
+	return parts[0], parts[1], nil
+}
+
 func duration(s string) time.Duration {
 	d, err := time.ParseDuration(s)
 	if err != nil {
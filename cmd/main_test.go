@@ -118,6 +118,256 @@ func TestFlags(t *testing.T) {
 			},
 			defaultVal: "./config/config.yml",
 		},
+		{
+			names:    []string{"--state-store"},
+			arg:      "file",
+			required: false,
+			want:     "file",
+			value: func() interface{} {
+				return cmdArgs.stateStore
+			},
+			defaultVal: "none",
+		},
+		{
+			names:    []string{"--state-file"},
+			arg:      "/var/lib/healthcat/state.json",
+			required: false,
+			want:     "/var/lib/healthcat/state.json",
+			value: func() interface{} {
+				return cmdArgs.stateFile
+			},
+			defaultVal: "./healthcat-state.json",
+		},
+		{
+			names:    []string{"--state-configmap"},
+			arg:      "monitoring/state",
+			required: false,
+			want:     "monitoring/state",
+			value: func() interface{} {
+				return cmdArgs.stateConfigMap
+			},
+			defaultVal: "healthcat/healthcat-state",
+		},
+		{
+			names:    []string{"--snapshot-interval"},
+			arg:      "1m",
+			required: false,
+			want:     duration("1m"),
+			value: func() interface{} {
+				return cmdArgs.snapshotInterval
+			},
+			defaultVal: duration("30s"),
+		},
+		{
+			names:    []string{"--not-ready-status"},
+			arg:      "503",
+			required: false,
+			want:     503,
+			value: func() interface{} {
+				return cmdArgs.notReadyStatus
+			},
+			defaultVal: 552,
+		},
+		{
+			names:    []string{"--failure-status"},
+			arg:      "503",
+			required: false,
+			want:     503,
+			value: func() interface{} {
+				return cmdArgs.failureStatus
+			},
+			defaultVal: 500,
+		},
+		{
+			names:    []string{"--min-targets"},
+			arg:      "5",
+			required: false,
+			want:     5,
+			value: func() interface{} {
+				return cmdArgs.minTargets
+			},
+			defaultVal: 0,
+		},
+		{
+			names:    []string{"--empty-policy"},
+			arg:      "unknown",
+			required: false,
+			want:     "unknown",
+			value: func() interface{} {
+				return cmdArgs.emptyPolicy
+			},
+			defaultVal: "healthy",
+		},
+		{
+			names:    []string{"--expected-configmap"},
+			arg:      "platform/expected",
+			required: false,
+			want:     "platform/expected",
+			value: func() interface{} {
+				return cmdArgs.expectedConfigMap
+			},
+			defaultVal: "",
+		},
+		{
+			names:    []string{"--cert-warning"},
+			arg:      "336h",
+			required: false,
+			want:     duration("336h"),
+			value: func() interface{} {
+				return cmdArgs.certWarning
+			},
+			defaultVal: duration("720h"),
+		},
+		{
+			names:    []string{"--cert-critical"},
+			arg:      "72h",
+			required: false,
+			want:     duration("72h"),
+			value: func() interface{} {
+				return cmdArgs.certCritical
+			},
+			defaultVal: duration("0s"),
+		},
+		{
+			names:    []string{"--flap-window"},
+			arg:      "10",
+			required: false,
+			want:     10,
+			value: func() interface{} {
+				return cmdArgs.flapWindow
+			},
+			defaultVal: 20,
+		},
+		{
+			names:    []string{"--flap-high-threshold"},
+			arg:      "60",
+			required: false,
+			want:     60,
+			value: func() interface{} {
+				return cmdArgs.flapHigh
+			},
+			defaultVal: 50,
+		},
+		{
+			names:    []string{"--flap-low-threshold"},
+			arg:      "30",
+			required: false,
+			want:     30,
+			value: func() interface{} {
+				return cmdArgs.flapLow
+			},
+			defaultVal: 25,
+		},
+		{
+			names:    []string{"--retry-interval"},
+			arg:      "10s",
+			required: false,
+			want:     duration("10s"),
+			value: func() interface{} {
+				return cmdArgs.retryInterval
+			},
+			defaultVal: duration("0s"),
+		},
+		{
+			names:    []string{"--discovery-paths"},
+			arg:      "/actuator/health,/health",
+			required: false,
+			want:     []string{"/actuator/health", "/health"},
+			value: func() interface{} {
+				return cmdArgs.discoveryPaths
+			},
+			defaultVal: []string{},
+		},
+		{
+			names:    []string{"--auth-token-file"},
+			arg:      "/etc/healthcat/tokens.csv",
+			required: false,
+			want:     "/etc/healthcat/tokens.csv",
+			value: func() interface{} {
+				return cmdArgs.authTokenFile
+			},
+			defaultVal: "",
+		},
+		{
+			names:    []string{"--auth-admins"},
+			arg:      "ops,system:masters",
+			required: false,
+			want:     []string{"ops", "system:masters"},
+			value: func() interface{} {
+				return cmdArgs.authAdmins
+			},
+			defaultVal: []string{},
+		},
+		{
+			names:    []string{"--tls-cert"},
+			arg:      "/etc/healthcat/tls/tls.crt",
+			required: false,
+			want:     "/etc/healthcat/tls/tls.crt",
+			value: func() interface{} {
+				return cmdArgs.tlsCert
+			},
+			defaultVal: "",
+		},
+		{
+			names:    []string{"--tls-key"},
+			arg:      "/etc/healthcat/tls/tls.key",
+			required: false,
+			want:     "/etc/healthcat/tls/tls.key",
+			value: func() interface{} {
+				return cmdArgs.tlsKey
+			},
+			defaultVal: "",
+		},
+		{
+			names:    []string{"--tls-client-ca"},
+			arg:      "/etc/healthcat/tls/ca.crt",
+			required: false,
+			want:     "/etc/healthcat/tls/ca.crt",
+			value: func() interface{} {
+				return cmdArgs.tlsClientCA
+			},
+			defaultVal: "",
+		},
+		{
+			names:    []string{"--tls-min-version"},
+			arg:      "1.3",
+			required: false,
+			want:     "1.3",
+			value: func() interface{} {
+				return cmdArgs.tlsMinVersion
+			},
+			defaultVal: "1.2",
+		},
+		{
+			names:    []string{"--admin-listen-address"},
+			arg:      "127.0.0.1",
+			required: false,
+			want:     "127.0.0.1",
+			value: func() interface{} {
+				return cmdArgs.adminHost
+			},
+			defaultVal: "*",
+		},
+		{
+			names:    []string{"--admin-port"},
+			arg:      "9090",
+			required: false,
+			want:     9090,
+			value: func() interface{} {
+				return cmdArgs.adminPort
+			},
+			defaultVal: 0,
+		},
+		{
+			names:    []string{"--discovery-ports"},
+			arg:      "8080,9090",
+			required: false,
+			want:     []int{8080, 9090},
+			value: func() interface{} {
+				return cmdArgs.discoveryPorts
+			},
+			defaultVal: []int{},
+		},
 	}
 
 	var required []string
@@ -174,6 +424,57 @@ func TestFlags(t *testing.T) {
 	})
 }
 
+func TestValidateStatusCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		failure  int
+		notReady int
+		ok       bool
+	}{
+		{"Defaults", defaultFailure, defaultNotReady, true},
+		{"ClientError", 429, defaultNotReady, true},
+		{"SuccessFailure", 200, defaultNotReady, false},
+		{"InvalidFailure", 1000, defaultNotReady, false},
+		{"RedirectNotReady", defaultFailure, 302, false},
+		{"InvalidNotReady", defaultFailure, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStatusCodes(&mainCmdArgs{failureStatus: tt.failure, notReadyStatus: tt.notReady})
+			if (err == nil) != tt.ok {
+				t.Errorf("got error %v, want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestClientCertsRequireTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmdArgs  mainCmdArgs
+		wantAuth bool
+	}{
+		{"NoTLS", mainCmdArgs{authClientCerts: true}, false},
+		{"NoClientCA", mainCmdArgs{authClientCerts: true, tlsCert: "tls.crt"}, false},
+		{"NoCertificate", mainCmdArgs{authClientCerts: true, tlsClientCA: "ca.crt"}, false},
+		{"TLS", mainCmdArgs{authClientCerts: true, tlsCert: "tls.crt", tlsClientCA: "ca.crt"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticators, err := newAuthenticators(&tt.cmdArgs)
+			if tt.wantAuth {
+				if err != nil || len(authenticators) != 1 {
+					t.Errorf("got %d authenticators and error %v, want the certificate one", len(authenticators), err)
+				}
+				return
+			}
+			if err == nil {
+				t.Error("want error for client certificates without TLS")
+			}
+		})
+	}
+}
+
 // resetCommand resets rootCmd for testing purposes
 func resetCommand(cmd *cobra.Command, args []string) {
 	cmd.RunE = func(*cobra.Command, []string) error { return nil }
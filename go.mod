@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/go-chi/chi v4.1.2+incompatible
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
@@ -11,4 +12,5 @@ require (
 	k8s.io/api v0.17.8
 	k8s.io/apimachinery v0.17.8
 	k8s.io/client-go v0.17.8
+	sigs.k8s.io/yaml v1.1.0
 )
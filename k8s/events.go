@@ -2,6 +2,8 @@ package k8s
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
@@ -9,15 +11,31 @@ import (
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+	"wiley.com/healthcat/checker"
 )
 
 // ServiceRegistry is TOOD
 //
 type ServiceRegistry interface {
-	Add(name, url string)
+	AddTarget(spec checker.TargetSpec)
+	UpdateTarget(spec checker.TargetSpec)
 	Delete(name string)
+	AddSource(name string)
+	SourceSynced(name string)
+	Heartbeat(name string, maxAge time.Duration)
 }
 
+const (
+	// sourceName identifies the event source in the registry
+	sourceName = "kubernetes"
+
+	// heartbeatInterval is the time between two heartbeats of an idle watch
+	heartbeatInterval = 10 * time.Second
+	// heartbeatAge is the time without heartbeats after which the watch is considered stuck
+	heartbeatAge = 3 * heartbeatInterval
+)
+
 // EventSource is TODO
 type EventSource struct {
 	Logger             *zap.Logger
@@ -25,13 +43,20 @@ type EventSource struct {
 	ExcludedNamespaces []string
 	Registry           ServiceRegistry
 
+	// DiscoveryPaths are the candidate health paths of the services without the chc/path
+	// annotation, tried on the DiscoveryPorts or on all the service ports. If empty, /healthz is used.
+	DiscoveryPaths []string
+	DiscoveryPorts []int
+
 	clientset *kubernetes.Clientset
 	slogger   *zap.SugaredLogger
+	known     map[string]string // resource versions of the services of the targets by target name
 }
 
 // Start starts the loop
 func (e *EventSource) Start() error {
 	e.slogger = e.Logger.Sugar()
+	e.known = make(map[string]string)
 
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -44,71 +69,224 @@ func (e *EventSource) Start() error {
 		return err
 	}
 
+	e.Registry.AddSource(sourceName)
+	e.Registry.Heartbeat(sourceName, heartbeatAge)
 	go e.Run()
 
 	return nil
 }
 
-// Run runs the event loop
+// Run runs the event loop.
+// The existing services are listed first to complete the initial sync,
+// then the changes are watched from the listed version on.
 func (e *EventSource) Run() {
-	serviceWatch, err := e.clientset.CoreV1().Services("").Watch(metav1.ListOptions{})
+	lw := &listWatch{
+		source:   sourceName,
+		registry: e.Registry,
+		slogger:  e.slogger,
+		list:     e.listServices,
+		watch: func(resourceVersion string) (watch.Interface, error) {
+			return e.clientset.CoreV1().Services("").Watch(metav1.ListOptions{ResourceVersion: resourceVersion})
+		},
+		handle: e.handleService,
+	}
+	lw.run()
+}
+
+// listServices syncs the targets with all the services, e.g. when the watched version has expired.
+// The services changed since the last list are updated, and the ones missing are deleted.
+func (e *EventSource) listServices() (string, error) {
+	services, err := e.clientset.CoreV1().Services("").List(metav1.ListOptions{})
 	if err != nil {
-		e.slogger.Errorf("Error while watching services: %v", err)
-		return
+		return "", fmt.Errorf("listing services: %v", err)
 	}
 
-	for event := range serviceWatch.ResultChan() {
-		switch event.Type {
-		case watch.Error:
-			e.slogger.Errorf("Error listening to service events: %v", event.Object)
-			break
-		case watch.Added:
-			e.addService(event.Object.(*v1.Service))
-		case watch.Deleted:
-			e.deleteService(event.Object.(*v1.Service))
-		case watch.Modified:
-			svc := event.Object.(*v1.Service)
-			// TODO: This may not work as the commands may not come in order.
-			// Consider using a single command channel in checker or
-			// introduce a separate command for updating service
-			e.deleteService(svc)
+	known := e.known
+	e.known = make(map[string]string, len(services.Items))
+	for i := range services.Items {
+		svc := &services.Items[i]
+		version, ok := known[makeTargetName(svc)]
+		switch {
+		case !ok:
 			e.addService(svc)
+		case version != svc.ResourceVersion:
+			e.updateService(svc)
 		default:
-			e.slogger.Info("Ignoring unsupported event: %s", event.Type)
+			e.known[makeTargetName(svc)] = version
+		}
+	}
+	for name := range known {
+		if _, ok := e.known[name]; !ok {
+			e.slogger.Infof("Service %s deleted while not watched", name)
+			e.Registry.Delete(name)
 		}
 	}
+	return services.ResourceVersion, nil
+}
+
+// handleService applies the service change and returns its resource version
+func (e *EventSource) handleService(event watch.Event) string {
+	svc, ok := event.Object.(*v1.Service)
+	if !ok {
+		e.slogger.Warnf("Ignoring unexpected object %T", event.Object)
+		return ""
+	}
+
+	switch event.Type {
+	case watch.Added:
+		e.addService(svc)
+	case watch.Deleted:
+		e.deleteService(svc)
+	case watch.Modified:
+		e.updateService(svc)
+	default:
+		e.slogger.Infof("Ignoring unsupported event: %s", event.Type)
+	}
+	return svc.ResourceVersion
 }
 
 // addService TODO
 func (e *EventSource) addService(svc *v1.Service) {
+	if spec, ok := e.targetSpec(svc); ok {
+		e.slogger.Infof("Added service: %s", spec.Name)
+		e.Registry.AddTarget(spec)
+		e.known[spec.Name] = svc.ResourceVersion
+	}
+}
+
+// updateService replaces the target of the modified service, keeping its health state
+func (e *EventSource) updateService(svc *v1.Service) {
+	if spec, ok := e.targetSpec(svc); ok {
+		e.slogger.Infof("Updated service: %s", spec.Name)
+		e.Registry.UpdateTarget(spec)
+		e.known[spec.Name] = svc.ResourceVersion
+	}
+}
+
+// targetSpec describes the target of the service from its annotations.
+// It returns false if the service is in an excluded namespace.
+func (e *EventSource) targetSpec(svc *v1.Service) (checker.TargetSpec, bool) {
 	if !matchFilters(svc.Namespace, e.Namespaces, e.ExcludedNamespaces) {
 		e.slogger.Debugf("Ignoring service %q in excluded namespace %q", svc.Name, svc.Namespace)
-		return
+		return checker.TargetSpec{}, false
 	}
 	schema := svc.ObjectMeta.Annotations["chc/schema"]
 	if schema == "" {
 		schema = "http"
 	}
 
+	port := svc.Spec.Ports[0].Port
+	targetName := makeTargetName(svc)
+
+	var url string
+	var candidates []string
 	path := svc.ObjectMeta.Annotations["chc/path"]
-	if path == "" {
-		path = "/healthz"
+	if path == "" && len(e.DiscoveryPaths) > 0 {
+		candidates = e.candidates(svc, schema, targetName)
+	} else {
+		if path == "" {
+			path = "/healthz"
+		}
+		url = fmt.Sprintf("%s://%s:%d%s", schema, targetName, port, path)
 	}
 
-	port := svc.Spec.Ports[0].Port
-	targetName := makeTargetName(svc)
-	e.slogger.Infof("Added service: %s", targetName)
-	e.Registry.Add(targetName,
-		fmt.Sprintf("%s://%s:%d%s",
-			schema,
-			targetName,
-			port,
-			path))
+	var silencedUntil time.Time
+	if until := svc.ObjectMeta.Annotations["chc/silence-until"]; until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			e.slogger.Warnf("Ignoring invalid chc/silence-until annotation of service %s: %v", targetName, err)
+		}
+		silencedUntil = t
+	}
+
+	var retryInterval time.Duration
+	if retry := svc.ObjectMeta.Annotations["chc/retry-interval"]; retry != "" {
+		d, err := time.ParseDuration(retry)
+		if err != nil || d < 0 {
+			e.slogger.Warnf("Ignoring invalid chc/retry-interval annotation of service %s: %q", targetName, retry)
+		} else {
+			retryInterval = d
+		}
+	}
+
+	var dependsOn []string
+	for _, name := range strings.Split(svc.ObjectMeta.Annotations["chc/depends-on"], ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !strings.Contains(name, ".") {
+			name = fmt.Sprintf("%s.%s", name, svc.Namespace)
+		}
+		dependsOn = append(dependsOn, name)
+	}
+
+	policy, err := checker.ParseFailurePolicy(
+		svc.ObjectMeta.Annotations["chc/ignore-errors"],
+		svc.ObjectMeta.Annotations["chc/accept-status"])
+	if err != nil {
+		e.slogger.Warnf("Ignoring invalid failure policy annotations of service %s: %v", targetName, err)
+		policy = nil
+	}
+
+	request, err := e.requestConfig(svc)
+	if err != nil {
+		e.slogger.Warnf("Ignoring invalid %s annotation of service %s: %v", requestAnnotation, targetName, err)
+	}
+
+	// The steps of a synthetic check replace the single check request, e.g.
+	//   - {method: POST, url: /login, extract: [{var: token, jsonPath: $.token}]}
+	//   - {url: /orders, headers: {Authorization: "Bearer ${token}"}}
+	var steps []checker.Step
+	if annotation := svc.ObjectMeta.Annotations["chc/steps"]; annotation != "" {
+		if err := yaml.Unmarshal([]byte(annotation), &steps); err != nil {
+			e.slogger.Warnf("Ignoring invalid chc/steps annotation of service %s: %v", targetName, err)
+			steps = nil
+		}
+	}
+
+	return checker.TargetSpec{
+		Name:              targetName,
+		Namespace:         svc.Namespace,
+		Labels:            svc.Labels,
+		SilencedUntil:     silencedUntil,
+		TLS:               e.tlsConfig(svc, targetName),
+		Request:           request,
+		Policy:            policy,
+		RetryInterval:     retryInterval,
+		DependsOn:         dependsOn,
+		Steps:             steps,
+		StepsOnTargetHost: true,
+		Candidates:        candidates,
+		URL:               url,
+	}, true
+}
+
+// candidates lists the candidate health URLs of the service, trying all the paths on each port in turn
+func (e *EventSource) candidates(svc *v1.Service, schema, targetName string) []string {
+	ports := e.DiscoveryPorts
+	if len(ports) == 0 {
+		for _, p := range svc.Spec.Ports {
+			ports = append(ports, int(p.Port))
+		}
+	}
+
+	candidates := make([]string, 0, len(ports)*len(e.DiscoveryPaths))
+	for _, port := range ports {
+		for _, path := range e.DiscoveryPaths {
+			candidates = append(candidates, fmt.Sprintf("%s://%s:%d%s", schema, targetName, port, path))
+		}
+	}
+	return candidates
 }
 
 // deleteService deletes a cluster service
 func (e *EventSource) deleteService(svc *v1.Service) {
-	e.Registry.Delete(makeTargetName(svc))
+	name := makeTargetName(svc)
+	if _, ok := e.known[name]; !ok {
+		return
+	}
+	delete(e.known, name)
+	e.Registry.Delete(name)
 }
 
 // matchFilters is a filter
@@ -0,0 +1,131 @@
+package k8s
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+	"wiley.com/healthcat/checker"
+)
+
+// expectedKey is the ConfigMap data key holding the expected services
+const expectedKey = "expected-services.yaml"
+
+// ExpectationRegistry keeps the expected services
+type ExpectationRegistry interface {
+	SetExpected(source string, expected []checker.Expectation) error
+	AddSource(name string)
+	SourceSynced(name string)
+	Heartbeat(name string, maxAge time.Duration)
+}
+
+// ExpectedSource keeps the expected services of the registry in sync
+// with the YAML list stored in a ConfigMap
+type ExpectedSource struct {
+	Logger    *zap.Logger
+	Namespace string
+	Name      string
+	Registry  ExpectationRegistry
+
+	clientset *kubernetes.Clientset
+	slogger   *zap.SugaredLogger
+}
+
+// Start starts the loop
+func (e *ExpectedSource) Start() error {
+	e.slogger = e.Logger.Sugar()
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+
+	e.clientset, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	e.Registry.AddSource(e.source())
+	e.Registry.Heartbeat(e.source(), heartbeatAge)
+	go e.Run()
+
+	return nil
+}
+
+// source identifies the ConfigMap in the registry
+func (e *ExpectedSource) source() string {
+	return fmt.Sprintf("configmap:%s/%s", e.Namespace, e.Name)
+}
+
+// Run reads the ConfigMap to complete the initial sync and then watches its changes
+func (e *ExpectedSource) Run() {
+	configMaps := e.clientset.CoreV1().ConfigMaps(e.Namespace)
+	lw := &listWatch{
+		source:   e.source(),
+		registry: e.Registry,
+		slogger:  e.slogger,
+		list:     e.read,
+		watch: func(resourceVersion string) (watch.Interface, error) {
+			return configMaps.Watch(metav1.ListOptions{
+				FieldSelector:   fields.OneTermEqualSelector("metadata.name", e.Name).String(),
+				ResourceVersion: resourceVersion,
+			})
+		},
+		handle: e.handle,
+	}
+	lw.run()
+}
+
+// read updates the expected services from the ConfigMap and returns its resource version
+func (e *ExpectedSource) read() (string, error) {
+	cm, err := e.clientset.CoreV1().ConfigMaps(e.Namespace).Get(e.Name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		e.slogger.Warnf("ConfigMap %s/%s with the expected services not found", e.Namespace, e.Name)
+		e.update(nil)
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("reading the expected services: %v", err)
+	}
+	e.update(cm)
+	return cm.ResourceVersion, nil
+}
+
+// handle applies the ConfigMap change and returns its resource version
+func (e *ExpectedSource) handle(event watch.Event) string {
+	cm, ok := event.Object.(*v1.ConfigMap)
+	if !ok {
+		e.slogger.Warnf("Ignoring unexpected object %T", event.Object)
+		return ""
+	}
+
+	if event.Type == watch.Deleted {
+		e.update(nil)
+	} else {
+		e.update(cm)
+	}
+	return cm.ResourceVersion
+}
+
+// update replaces the expected services with the ones listed in the ConfigMap
+func (e *ExpectedSource) update(cm *v1.ConfigMap) {
+	var expected []checker.Expectation
+	if cm != nil {
+		if err := yaml.Unmarshal([]byte(cm.Data[expectedKey]), &expected); err != nil {
+			e.slogger.Errorf("Ignoring invalid expected services in %s: %v", e.source(), err)
+			return
+		}
+	}
+
+	if err := e.Registry.SetExpected(e.source(), expected); err != nil {
+		e.slogger.Errorf("Ignoring invalid expected services in %s: %v", e.source(), err)
+	}
+}
@@ -0,0 +1,135 @@
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// namespaceSourceName identifies the namespace source in the registry
+	namespaceSourceName = "namespaces"
+
+	// thresholdAnnotation overrides the percentage of healthy services for the namespace to be healthy
+	thresholdAnnotation = "chc/status-threshold"
+)
+
+// NamespaceRegistry keeps the thresholds of the namespaces
+type NamespaceRegistry interface {
+	SetNamespaceThreshold(namespace string, threshold int) error
+	AddSource(name string)
+	SourceSynced(name string)
+	Heartbeat(name string, maxAge time.Duration)
+}
+
+// NamespaceSource keeps the thresholds of the namespaces of the registry in sync
+// with their annotations
+type NamespaceSource struct {
+	Logger   *zap.Logger
+	Registry NamespaceRegistry
+
+	clientset *kubernetes.Clientset
+	slogger   *zap.SugaredLogger
+	known     map[string]bool // names of the listed namespaces
+}
+
+// Start starts the loop
+func (n *NamespaceSource) Start() error {
+	n.slogger = n.Logger.Sugar()
+	n.known = make(map[string]bool)
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+
+	n.clientset, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	n.Registry.AddSource(namespaceSourceName)
+	n.Registry.Heartbeat(namespaceSourceName, heartbeatAge)
+	go n.Run()
+
+	return nil
+}
+
+// Run lists the namespaces to complete the initial sync and then watches their changes
+func (n *NamespaceSource) Run() {
+	lw := &listWatch{
+		source:   namespaceSourceName,
+		registry: n.Registry,
+		slogger:  n.slogger,
+		list:     n.list,
+		watch: func(resourceVersion string) (watch.Interface, error) {
+			return n.clientset.CoreV1().Namespaces().Watch(metav1.ListOptions{ResourceVersion: resourceVersion})
+		},
+		handle: n.handle,
+	}
+	lw.run()
+}
+
+// list updates the thresholds of all the namespaces and returns their resource version.
+// The namespaces deleted since the last list get the cluster threshold back.
+func (n *NamespaceSource) list() (string, error) {
+	namespaces, err := n.clientset.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("listing namespaces: %v", err)
+	}
+
+	known := n.known
+	n.known = make(map[string]bool, len(namespaces.Items))
+	for i := range namespaces.Items {
+		n.update(&namespaces.Items[i])
+	}
+	for name := range known {
+		if !n.known[name] {
+			n.Registry.SetNamespaceThreshold(name, 0)
+		}
+	}
+	return namespaces.ResourceVersion, nil
+}
+
+// handle applies the namespace change and returns its resource version
+func (n *NamespaceSource) handle(event watch.Event) string {
+	ns, ok := event.Object.(*v1.Namespace)
+	if !ok {
+		n.slogger.Warnf("Ignoring unexpected object %T", event.Object)
+		return ""
+	}
+
+	if event.Type == watch.Deleted {
+		delete(n.known, ns.Name)
+		n.Registry.SetNamespaceThreshold(ns.Name, 0)
+	} else {
+		n.update(ns)
+	}
+	return ns.ResourceVersion
+}
+
+// update sets the threshold of the namespace from its annotation, or restores the cluster one
+func (n *NamespaceSource) update(ns *v1.Namespace) {
+	n.known[ns.Name] = true
+
+	var threshold int
+	if annotation, ok := ns.Annotations[thresholdAnnotation]; ok {
+		var err error
+		if threshold, err = strconv.Atoi(annotation); err != nil {
+			n.slogger.Warnf("Ignoring invalid %s annotation of namespace %s: %q", thresholdAnnotation, ns.Name, annotation)
+			threshold = 0
+		}
+	}
+
+	if err := n.Registry.SetNamespaceThreshold(ns.Name, threshold); err != nil {
+		n.slogger.Warnf("Ignoring invalid %s annotation of namespace %s: %v", thresholdAnnotation, ns.Name, err)
+		n.Registry.SetNamespaceThreshold(ns.Name, 0)
+	}
+}
@@ -0,0 +1,139 @@
+package k8s
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+	"wiley.com/healthcat/checker"
+)
+
+// requestAnnotation holds the headers and the credentials of the check request in YAML, e.g.
+//
+//	headers:
+//	  Host: api.example.com
+//	  X-Health-Key: {secret: health, key: api-key}
+//	basicAuth:
+//	  username: health
+//	  password: {secret: health, key: password}
+const requestAnnotation = "chc/request"
+
+// requestSpec is the content of the request annotation
+type requestSpec struct {
+	Headers     map[string]valueRef `json:"headers,omitempty"`
+	BearerToken *valueRef           `json:"bearerToken,omitempty"`
+	BasicAuth   *struct {
+		Username string   `json:"username"`
+		Password valueRef `json:"password"`
+	} `json:"basicAuth,omitempty"`
+}
+
+// valueRef is a request value given literally, as a plain string or in value,
+// or read from a key of a Secret in the service namespace. The environment and the files
+// of healthcat are refused, since anyone annotating a service would get them sent to it.
+type valueRef struct {
+	Value  string `json:"value,omitempty"`
+	Env    string `json:"env,omitempty"`
+	File   string `json:"file,omitempty"`
+	Secret string `json:"secret,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+// UnmarshalJSON accepts a plain string as a literal value
+func (r *valueRef) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &r.Value)
+	}
+
+	type plain valueRef
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+// source creates the value source of the reference
+func (r *valueRef) source(namespace string, clientset kubernetes.Interface) (checker.ValueSource, error) {
+	switch {
+	case r.Env != "":
+		return nil, fmt.Errorf("environment variable %s not allowed in annotations, use a secret", r.Env)
+	case r.File != "":
+		return nil, fmt.Errorf("file %s not allowed in annotations, use a secret", r.File)
+	case r.Secret != "":
+		if r.Key == "" {
+			return nil, fmt.Errorf("missing key of secret %s", r.Secret)
+		}
+		return &SecretValue{Namespace: namespace, Name: r.Secret, Key: r.Key, clientset: clientset}, nil
+	default:
+		return checker.Literal(r.Value), nil
+	}
+}
+
+// SecretValue reads a request value from a key of a Secret
+type SecretValue struct {
+	Namespace string
+	Name      string
+	Key       string
+
+	clientset kubernetes.Interface
+}
+
+// Value reads the Secret
+func (s *SecretValue) Value() (string, error) {
+	secret, err := s.clientset.CoreV1().Secrets(s.Namespace).Get(s.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secret.Data[s.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %s", s.Namespace, s.Name, s.Key)
+	}
+	return string(value), nil
+}
+
+// requestConfig reads the headers and the credentials of the service from its annotation.
+// It returns nil if there are none.
+func (e *EventSource) requestConfig(svc *v1.Service) (*checker.RequestConfig, error) {
+	annotation := svc.ObjectMeta.Annotations[requestAnnotation]
+	if annotation == "" {
+		return nil, nil
+	}
+
+	var spec requestSpec
+	if err := yaml.Unmarshal([]byte(annotation), &spec); err != nil {
+		return nil, err
+	}
+	if spec.BearerToken != nil && spec.BasicAuth != nil {
+		return nil, errors.New("bearerToken and basicAuth are mutually exclusive")
+	}
+
+	config := &checker.RequestConfig{
+		Headers: make(map[string]checker.ValueSource, len(spec.Headers)),
+	}
+	for name, ref := range spec.Headers {
+		source, err := ref.source(svc.Namespace, e.clientset)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %v", name, err)
+		}
+		config.Headers[name] = source
+	}
+
+	if spec.BearerToken != nil {
+		source, err := spec.BearerToken.source(svc.Namespace, e.clientset)
+		if err != nil {
+			return nil, fmt.Errorf("bearer token: %v", err)
+		}
+		config.BearerToken = source
+	}
+
+	if spec.BasicAuth != nil {
+		source, err := spec.BasicAuth.Password.source(svc.Namespace, e.clientset)
+		if err != nil {
+			return nil, fmt.Errorf("basic auth password: %v", err)
+		}
+		config.BasicAuth = &checker.BasicAuth{Username: spec.BasicAuth.Username, Password: source}
+	}
+	return config, nil
+}
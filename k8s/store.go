@@ -0,0 +1,95 @@
+package k8s
+
+import (
+	"encoding/json"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"wiley.com/healthcat/checker"
+)
+
+// snapshotKey is the ConfigMap data key holding the snapshot
+const snapshotKey = "snapshot.json"
+
+// ConfigMapStore keeps the checker state snapshot in a ConfigMap
+type ConfigMapStore struct {
+	Namespace string
+	Name      string
+
+	clientset kubernetes.Interface
+}
+
+// NewConfigMapStore creates a store for the given ConfigMap using the in-cluster configuration
+func NewConfigMapStore(namespace, name string) (*ConfigMapStore, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigMapStore{
+		Namespace: namespace,
+		Name:      name,
+		clientset: clientset,
+	}, nil
+}
+
+// Load reads the snapshot from the ConfigMap
+func (s *ConfigMapStore) Load() (*checker.Snapshot, error) {
+	cm, err := s.clientset.CoreV1().ConfigMaps(s.Namespace).Get(s.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	data, ok := cm.Data[snapshotKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var snapshot checker.Snapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// Save writes the snapshot to the ConfigMap, creating it if missing
+func (s *ConfigMapStore) Save(snapshot *checker.Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.Namespace)
+	cm, err := configMaps.Get(s.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.Name,
+				Namespace: s.Namespace,
+			},
+			Data: map[string]string{snapshotKey: string(data)},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[snapshotKey] = string(data)
+	_, err = configMaps.Update(cm)
+	return err
+}
@@ -0,0 +1,69 @@
+package k8s
+
+import (
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"wiley.com/healthcat/checker"
+)
+
+// TLS annotations of the services
+const (
+	tlsSecretAnnotation     = "chc/tls-secret"               // Secret in the service namespace holding ca.crt, tls.crt and tls.key
+	tlsServerNameAnnotation = "chc/tls-server-name"          // Server name verified in the service certificate
+	tlsInsecureAnnotation   = "chc/tls-insecure-skip-verify" // Skips the verification of the service certificate
+)
+
+// SecretTLSSource reads the TLS material from the ca.crt, tls.crt and tls.key keys of a Secret
+type SecretTLSSource struct {
+	Namespace string
+	Name      string
+
+	clientset kubernetes.Interface
+}
+
+// Load reads the Secret
+func (s *SecretTLSSource) Load() (*checker.TLSMaterial, error) {
+	secret, err := s.clientset.CoreV1().Secrets(s.Namespace).Get(s.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &checker.TLSMaterial{
+		CA:   secret.Data["ca.crt"],
+		Cert: secret.Data[v1.TLSCertKey],
+		Key:  secret.Data[v1.TLSPrivateKeyKey],
+	}, nil
+}
+
+// tlsConfig reads the TLS settings of the service from its annotations.
+// It returns nil if there are none.
+func (e *EventSource) tlsConfig(svc *v1.Service, targetName string) *checker.TLSConfig {
+	annotations := svc.ObjectMeta.Annotations
+	config := &checker.TLSConfig{
+		ServerName: annotations[tlsServerNameAnnotation],
+	}
+
+	if insecure := annotations[tlsInsecureAnnotation]; insecure != "" {
+		skip, err := strconv.ParseBool(insecure)
+		if err != nil {
+			e.slogger.Warnf("Ignoring invalid %s annotation of service %s: %v", tlsInsecureAnnotation, targetName, err)
+		}
+		config.InsecureSkipVerify = skip
+	}
+
+	if secret := annotations[tlsSecretAnnotation]; secret != "" {
+		config.Source = &SecretTLSSource{
+			Namespace: svc.Namespace,
+			Name:      secret,
+			clientset: e.clientset,
+		}
+	}
+
+	if config.Source == nil && config.ServerName == "" && !config.InsecureSkipVerify {
+		return nil
+	}
+	return config
+}
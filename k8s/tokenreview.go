@@ -0,0 +1,47 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// TokenReviewer checks bearer tokens with the TokenReview API of the cluster
+type TokenReviewer struct {
+	clientset kubernetes.Interface
+}
+
+// NewTokenReviewer creates a token reviewer using the in-cluster configuration
+func NewTokenReviewer() (*TokenReviewer, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenReviewer{clientset: clientset}, nil
+}
+
+// Review returns the user name and groups of the token, or an error if the token is not authenticated
+func (t *TokenReviewer) Review(token string) (string, []string, error) {
+	review, err := t.clientset.AuthenticationV1().TokenReviews().Create(&authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("token review failed: %v", err)
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return "", nil, fmt.Errorf("token not authenticated: %s", review.Status.Error)
+		}
+		return "", nil, errors.New("token not authenticated")
+	}
+	return review.Status.User.Username, review.Status.User.Groups, nil
+}
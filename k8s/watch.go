@@ -0,0 +1,125 @@
+package k8s
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	// maxWatchFailures is the number of consecutive list or watch failures after which a source gives up
+	maxWatchFailures = 5
+	// watchRetryInterval is the time before retrying after the first failure, doubled after each other one
+	watchRetryInterval = time.Second
+)
+
+// sourceRegistry tracks the initial sync and the heartbeat of the sources
+type sourceRegistry interface {
+	SourceSynced(name string)
+	Heartbeat(name string, maxAge time.Duration)
+}
+
+// listWatch keeps a source in sync with the objects of a resource. The objects are listed
+// first to complete the initial sync, then their changes are watched from the listed version on.
+//
+// The watch is restarted whenever the API server closes it, and the objects are listed again
+// when the watched version has expired. The other failures are retried, and the loop only
+// stops after maxWatchFailures consecutive ones, so that the heartbeat of the source goes stale.
+type listWatch struct {
+	source   string
+	registry sourceRegistry
+	slogger  *zap.SugaredLogger
+
+	// list syncs the source with all the objects and returns their resource version
+	list func() (string, error)
+	// watch watches the changes of the objects after the resource version
+	watch func(resourceVersion string) (watch.Interface, error)
+	// handle applies the change and returns the resource version of its object,
+	// or an empty one if the object is not expected
+	handle func(event watch.Event) string
+}
+
+// run lists and watches the objects until the failures persist
+func (l *listWatch) run() {
+	var resourceVersion string
+	synced, listed := false, false
+	failures := 0
+	for {
+		if !listed {
+			version, err := l.list()
+			if err != nil {
+				if !l.retry(&failures, err) {
+					return
+				}
+				continue
+			}
+			resourceVersion, listed = version, true
+			if !synced {
+				l.registry.SourceSynced(l.source)
+				synced = true
+			}
+			l.registry.Heartbeat(l.source, heartbeatAge)
+		}
+
+		objectWatch, err := l.watch(resourceVersion)
+		if err == nil {
+			resourceVersion, err = l.watchEvents(objectWatch, resourceVersion, &failures)
+		}
+		switch {
+		case err == nil:
+			l.slogger.Debugf("Watch of %s closed, restarting", l.source)
+		case errors.IsResourceExpired(err) || errors.IsGone(err):
+			l.slogger.Infof("Watched version of %s expired, listing again: %v", l.source, err)
+			listed = false
+		case !l.retry(&failures, err):
+			return
+		}
+	}
+}
+
+// watchEvents handles the events until the watch is closed or fails.
+// It returns the last seen resource version, and the error if the watch failed.
+func (l *listWatch) watchEvents(objectWatch watch.Interface, resourceVersion string, failures *int) (string, error) {
+	defer objectWatch.Stop()
+
+	beat := time.NewTicker(heartbeatInterval)
+	defer beat.Stop()
+
+	for {
+		select {
+		case event, ok := <-objectWatch.ResultChan():
+			if !ok {
+				*failures = 0
+				return resourceVersion, nil
+			}
+			if event.Type == watch.Error {
+				return resourceVersion, errors.FromObject(event.Object)
+			}
+
+			if version := l.handle(event); version != "" {
+				resourceVersion = version
+			}
+			*failures = 0
+		case <-beat.C:
+		}
+		l.registry.Heartbeat(l.source, heartbeatAge)
+	}
+}
+
+// retry waits before the next attempt after a failure.
+// It returns false once the failures persist.
+func (l *listWatch) retry(failures *int, err error) bool {
+	*failures++
+	if *failures >= maxWatchFailures {
+		l.slogger.Errorf("Stopping the sync of %s after %d consecutive failures: %v", l.source, *failures, err)
+		return false
+	}
+
+	wait := watchRetryInterval << (*failures - 1)
+	l.slogger.Warnf("Error syncing %s, retrying in %s: %v", l.source, wait, err)
+	l.registry.Heartbeat(l.source, heartbeatAge)
+	time.Sleep(wait)
+	return true
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+)
+
+// Role is the access level of an identity
+type Role string
+
+const (
+	// RoleReadOnly can only read the state
+	RoleReadOnly Role = "read-only"
+	// RoleAdmin can also change what defines the cluster health, e.g. the services and the silences
+	RoleAdmin Role = "admin"
+)
+
+// Identity is the authenticated caller of the API
+type Identity struct {
+	Name   string
+	Role   Role
+	Method string // How the caller was authenticated, e.g. token
+}
+
+// Authenticator identifies the caller of a request. It returns no identity
+// and no error if the request holds no credentials it can check.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// bearerToken returns the bearer token of the request, if any
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return strings.TrimSpace(auth[len(prefix):])
+	}
+	return ""
+}
+
+// staticToken is a token of a token file
+type staticToken struct {
+	token    string
+	identity Identity
+}
+
+// TokenFile authenticates the static bearer tokens listed in a file
+type TokenFile struct {
+	tokens []staticToken
+}
+
+// LoadTokenFile reads the static bearer tokens from the file, one per line as
+// token,name,role with the read-only or admin role. Empty lines and lines starting
+// with # are ignored.
+func LoadTokenFile(path string) (*TokenFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tf := &TokenFile{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, ",")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%s:%d: want token,name,role", path, n)
+		}
+		role := Role(strings.TrimSpace(parts[2]))
+		if role != RoleReadOnly && role != RoleAdmin {
+			return nil, fmt.Errorf("%s:%d: unknown role %q, want %s or %s", path, n, role, RoleReadOnly, RoleAdmin)
+		}
+		tf.tokens = append(tf.tokens, staticToken{
+			token:    strings.TrimSpace(parts[0]),
+			identity: Identity{Name: strings.TrimSpace(parts[1]), Role: role, Method: "token"},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// Authenticate looks the bearer token up in the file
+func (tf *TokenFile) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	for _, t := range tf.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.token), []byte(token)) == 1 {
+			identity := t.identity
+			return &identity, nil
+		}
+	}
+	return nil, nil
+}
+
+// TokenReviewer checks a bearer token, e.g. with the Kubernetes TokenReview API
+type TokenReviewer interface {
+	Review(token string) (name string, groups []string, err error)
+}
+
+// TokenReviewAuthenticator authenticates the bearer tokens with a TokenReviewer.
+// The users or the members of the groups listed in Admins get the admin role.
+type TokenReviewAuthenticator struct {
+	Reviewer TokenReviewer
+	Admins   []string
+}
+
+// Authenticate reviews the bearer token
+func (a *TokenReviewAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	name, groups, err := a.Reviewer.Review(token)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Name: name, Role: role(a.Admins, name, groups), Method: "token-review"}, nil
+}
+
+// CertificateAuthenticator authenticates the client certificates verified by the server.
+// The identity is the certificate common name and its groups are the organizations.
+// The identities or the members of the groups listed in Admins get the admin role.
+type CertificateAuthenticator struct {
+	Admins []string
+}
+
+// Authenticate reads the verified client certificate
+func (a *CertificateAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if subject.CommonName == "" {
+		return nil, errors.New("client certificate without common name")
+	}
+	return &Identity{
+		Name:   subject.CommonName,
+		Role:   role(a.Admins, subject.CommonName, subject.Organization),
+		Method: "certificate",
+	}, nil
+}
+
+// role grants the admin role to the listed identities and groups
+func role(admins []string, name string, groups []string) Role {
+	for _, admin := range admins {
+		if admin == name {
+			return RoleAdmin
+		}
+		for _, group := range groups {
+			if admin == group {
+				return RoleAdmin
+			}
+		}
+	}
+	return RoleReadOnly
+}
+
+// authenticate identifies the caller with the first authenticator recognizing its credentials
+func authenticate(authenticators []Authenticator, r *http.Request) (*Identity, error) {
+	for _, a := range authenticators {
+		identity, err := a.Authenticate(r)
+		if err != nil || identity != nil {
+			return identity, err
+		}
+	}
+	return nil, nil
+}
+
+// mutating reports whether the request may change the state
+func mutating(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// adminOnly reports whether the request reads the debug profiles or the configuration,
+// which may hold the resolved credentials of the checks
+func adminOnly(r *http.Request) bool {
+	return r.URL.Path == "/config" || r.URL.Path == "/debug" || strings.HasPrefix(r.URL.Path, "/debug/")
+}
+
+// authorize requires the admin role for the mutating requests and the admin only reads,
+// and logs each of them in the audit log with the identity of the caller.
+// The other read requests are not checked. Without authenticators, all the requests
+// are allowed and the remote address of the caller is logged as its identity.
+func authorize(authenticators []Authenticator, audit *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			message := "API mutation"
+			if !mutating(r) {
+				if !adminOnly(r) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				message = "API admin read"
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			identity, err := authenticate(authenticators, r)
+			if len(authenticators) == 0 {
+				identity = &Identity{Name: r.RemoteAddr, Role: RoleAdmin, Method: "none"}
+			}
+			switch {
+			case err != nil || identity == nil:
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(ww, "Unauthorized", http.StatusUnauthorized)
+			case identity.Role != RoleAdmin:
+				http.Error(ww, "Forbidden", http.StatusForbidden)
+			default:
+				next.ServeHTTP(ww, r)
+			}
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			fields := []zap.Field{
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("ip", r.RemoteAddr),
+				zap.Int("status", status),
+			}
+			if identity != nil {
+				fields = append(fields,
+					zap.String("identity", identity.Name),
+					zap.String("role", string(identity.Role)),
+					zap.String("auth", identity.Method))
+			}
+			if err != nil {
+				fields = append(fields, zap.Error(err))
+			}
+			audit.Info(message, fields...)
+		})
+	}
+}
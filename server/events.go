@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"wiley.com/healthcat/checker"
+)
+
+// keepAliveInterval is the time between comments sent to idle event streams
+// so that proxies do not close the connection
+const keepAliveInterval = 15 * time.Second
+
+// streamEvents serves the checker events as a Server-Sent Events stream.
+//
+// The stream is resumed from the replay buffer when the client sends
+// the Last-Event-ID header, and can be limited to the targets of the
+// namespaces given by the "namespace" query parameters.
+func streamEvents(sr StateReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		var filter checker.EventFilter
+		if namespaces := r.URL.Query()["namespace"]; len(namespaces) > 0 {
+			filter = checker.NamespaceFilter(namespaces...)
+		}
+
+		var events <-chan checker.Event
+		var cancel func()
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			lastID, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+			events, cancel = sr.SubscribeFrom(lastID, filter)
+		} else {
+			events, cancel = sr.Subscribe(filter)
+		}
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := writeEvent(w, e); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e checker.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
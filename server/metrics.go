@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"wiley.com/healthcat/checker"
+)
+
+// metricsContentType is the content type of the Prometheus text format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// serviceStatuses are the values of the status label of healthcat_service_status
+var serviceStatuses = []checker.ServiceStatus{
+	checker.ServiceHealthy,
+	checker.ServiceFailed,
+	checker.ServiceDegraded,
+	checker.ServiceFlapping,
+	checker.ServiceImpacted,
+	checker.ServiceMissing,
+}
+
+// labelEscaper escapes the label values of the Prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter writes the metrics in the Prometheus text format
+type metricsWriter struct {
+	w io.Writer
+}
+
+// family writes the help and the type of a metric family
+func (m *metricsWriter) family(name, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// sample writes a sample of the metric with the label name-value pairs
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	if len(labels) > 1 {
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(m.w, "%s %g\n", b.String(), value)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeMetrics exposes the cluster state in the Prometheus text format
+func writeMetrics(sr StateReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := sr.State()
+		services := state.Services
+		sort.Slice(services, func(i, j int) bool {
+			return services[i].Name < services[j].Name
+		})
+
+		w.Header().Set("Content-Type", metricsContentType)
+		m := &metricsWriter{w: w}
+		cluster := state.Cluster.Name
+
+		m.family("healthcat_cluster_healthy", "Whether the cluster is healthy.")
+		m.sample("healthcat_cluster_healthy", boolValue(state.Cluster.Healthy), "cluster", cluster)
+
+		m.family("healthcat_cluster_services", "Number of monitored services.")
+		m.sample("healthcat_cluster_services", float64(state.Cluster.Total), "cluster", cluster)
+
+		m.family("healthcat_cluster_failed_services", "Number of failed monitored services.")
+		m.sample("healthcat_cluster_failed_services", float64(state.Cluster.Failed), "cluster", cluster)
+
+		m.family("healthcat_service_healthy", "Whether the service is healthy.")
+		for _, svc := range services {
+			m.sample("healthcat_service_healthy", boolValue(svc.Healthy),
+				"cluster", cluster, "service", svc.Name, "namespace", svc.Namespace)
+		}
+
+		m.family("healthcat_service_status", "Whether the service has the status, one series per status.")
+		for _, svc := range services {
+			for _, status := range serviceStatuses {
+				m.sample("healthcat_service_status", boolValue(svc.Status == status),
+					"cluster", cluster, "service", svc.Name, "namespace", svc.Namespace, "status", string(status))
+			}
+		}
+
+		m.family("healthcat_service_error", "Class of the error of the last check of the failed service.")
+		for _, svc := range services {
+			if svc.ErrorClass != "" {
+				m.sample("healthcat_service_error", 1,
+					"cluster", cluster, "service", svc.Name, "namespace", svc.Namespace, "class", string(svc.ErrorClass))
+			}
+		}
+
+		m.family("healthcat_service_cert_expiry_timestamp_seconds", "Earliest expiry of the certificate chain of the service.")
+		for _, svc := range services {
+			if svc.CertExpiry != nil {
+				m.sample("healthcat_service_cert_expiry_timestamp_seconds", float64(svc.CertExpiry.Unix()),
+					"cluster", cluster, "service", svc.Name, "namespace", svc.Namespace)
+			}
+		}
+	}
+}
@@ -2,15 +2,19 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"go.uber.org/zap"
 	"wiley.com/healthcat/checker"
 	chczap "wiley.com/healthcat/logger"
@@ -20,9 +24,57 @@ import (
 // Server properties
 // TODO: add better descriptipn
 type Server struct {
-	Address string
-	Checker *checker.Checker
-	Logger  *zap.Logger
+	Address        string
+	Checker        *checker.Checker
+	Logger         *zap.Logger
+	NotReadyStatus int // Status code reported by the failed readiness check
+	FailureStatus  int // Status code reported by /status when the cluster is unhealthy
+
+	// Authenticators identify the callers of the mutating endpoints, which then require
+	// the admin role. The mutating endpoints are open if there are none.
+	Authenticators []Authenticator
+
+	// TLS serves the API over TLS if set, e.g. built by TLSOptions.Config
+	TLS *tls.Config
+
+	// AdminAddress is the address of the admin listener, serving the mutating, metrics and
+	// debug routes. Address then only serves the read-only routes. There is a single
+	// listener serving all the routes but the debug ones if it is not set.
+	AdminAddress string
+	// Config is the effective configuration dumped by /config on the admin listener
+	Config interface{}
+}
+
+// defaultNotReadyStatus is the status code reported by the failed readiness check
+// when none is configured
+const defaultNotReadyStatus = 552
+
+// defaultFailureStatus is the status code reported by /status when the cluster is unhealthy
+// and none is configured
+const defaultFailureStatus = http.StatusInternalServerError
+
+// listener selects the routes of a router
+type listener int
+
+const (
+	// combinedListener serves all the routes but the debug ones, when there is no admin listener
+	combinedListener listener = iota
+	// publicListener serves the read-only routes
+	publicListener
+	// adminListener serves all the routes, including the debug ones
+	adminListener
+)
+
+// writeTimeout is the maximum time to serve a request, except the streamed ones
+const writeTimeout = 5 * time.Second
+
+// options customizes the HTTP router
+type options struct {
+	notReadyStatus int
+	failureStatus  int
+	authenticators []Authenticator
+	listener       listener
+	config         interface{}
 }
 
 // StateReporter methods
@@ -30,8 +82,18 @@ type StateReporter interface {
 	Add(name, url string)
 	Delete(url string)
 	State() checker.ClusterState
+	Query(q checker.ServiceQuery) (checker.ClusterState, error)
+	NamespaceStatus(namespace string) (checker.NamespaceStatus, bool)
 	Healthy() bool
 	Ready() bool
+	ReadinessChecks() []checker.Check
+	Alive() bool
+	LivenessChecks() []checker.Check
+	Subscribe(filter checker.EventFilter) (<-chan checker.Event, func())
+	SubscribeFrom(lastID uint64, filter checker.EventFilter) (<-chan checker.Event, func())
+	AddSilence(s checker.Silence) (checker.Silence, error)
+	DeleteSilence(id string) bool
+	ListSilences() []checker.Silence
 }
 
 // Run HTTP server
@@ -42,70 +104,202 @@ func (s *Server) Run() {
 
 	logger := s.Logger.Sugar()
 
-	httpServer := http.Server{
-		Addr:         s.Address,
-		Handler:      router(s.Checker, s.Logger),
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
-		IdleTimeout:  30 * time.Second,
+	opts := options{
+		notReadyStatus: s.NotReadyStatus,
+		failureStatus:  s.FailureStatus,
+		authenticators: s.Authenticators,
+		config:         s.Config,
+	}
+
+	httpServers := []*http.Server{s.httpServer(s.Address, opts)}
+	if s.AdminAddress != "" {
+		publicOpts, adminOpts := opts, opts
+		publicOpts.listener = publicListener
+		adminOpts.listener = adminListener
+		httpServers = []*http.Server{s.httpServer(s.Address, publicOpts), s.httpServer(s.AdminAddress, adminOpts)}
 	}
 
 	go func() {
 		<-interrupted
 		logger.Info("Stopping CHC")
 		s.Checker.Stop()
-		httpServer.Shutdown(context.Background())
+		for _, httpServer := range httpServers {
+			httpServer.Shutdown(context.Background())
+		}
 	}()
 
 	logger.Infof("Starting CHC %s on %s", version.Version, s.Address)
+	if s.AdminAddress != "" {
+		logger.Infof("Serving the admin routes on %s", s.AdminAddress)
+	}
 
-	err := httpServer.ListenAndServe()
-	if err != nil && err != http.ErrServerClosed {
-		panic(err)
+	errs := make(chan error, len(httpServers))
+	for _, httpServer := range httpServers {
+		go func(httpServer *http.Server) {
+			if s.TLS != nil {
+				errs <- httpServer.ListenAndServeTLS("", "")
+			} else {
+				errs <- httpServer.ListenAndServe()
+			}
+		}(httpServer)
+	}
+	for range httpServers {
+		if err := <-errs; err != nil && err != http.ErrServerClosed {
+			panic(err)
+		}
 	}
 }
 
+// httpServer creates the HTTP server of a listener
+func (s *Server) httpServer(address string, opts options) *http.Server {
+	// There is no write timeout as /events streams the response for as long
+	// as the client stays connected, the other routes are limited by the router
+	return &http.Server{
+		Addr:        address,
+		Handler:     router(s.Checker, s.Logger, opts),
+		ReadTimeout: 5 * time.Second,
+		IdleTimeout: 30 * time.Second,
+		TLSConfig:   s.TLS,
+	}
+}
+
+// writeChecks lists the checks in the style of the Kubernetes health endpoints,
+// followed by the overall result
+func writeChecks(w http.ResponseWriter, checks []checker.Check, failureStatus int, failure string) {
+	for _, check := range checks {
+		if !check.OK {
+			writeCheckList(w, checks, failureStatus, failure)
+			return
+		}
+	}
+	writeCheckList(w, checks, http.StatusOK, "OK\n")
+}
+
+// writeCheckList lists the checks in the style of the Kubernetes health endpoints,
+// followed by the given result
+func writeCheckList(w http.ResponseWriter, checks []checker.Check, status int, result string) {
+	var b strings.Builder
+	for _, check := range checks {
+		if check.OK {
+			fmt.Fprintf(&b, "[+]%s ok\n", check.Name)
+		} else {
+			fmt.Fprintf(&b, "[-]%s failed: %s\n", check.Name, check.Message)
+		}
+	}
+	b.WriteString(result)
+
+	w.WriteHeader(status)
+	io.WriteString(w, b.String())
+}
+
 //
 // HTTP router
 // TODO: add better descriptipn
-func router(sr StateReporter, log *zap.Logger) http.Handler {
+func router(sr StateReporter, log *zap.Logger, opts options) http.Handler {
+	if opts.notReadyStatus == 0 {
+		opts.notReadyStatus = defaultNotReadyStatus
+	}
+	if opts.failureStatus == 0 {
+		opts.failureStatus = defaultFailureStatus
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(chczap.Chczap(log, time.RFC3339, true))
 	r.Use(chczap.RecoveryWithZap(log, false))
+	r.Use(authorize(opts.authenticators, log.Named("audit")))
 
-	r.Get("/status", func(w http.ResponseWriter, r *http.Request) {
-		if sr.Healthy() {
-			w.WriteHeader(http.StatusOK)
-			io.WriteString(w, "OK\n")
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, "Failure\n")
-		}
-	})
+	// The streamed and profiling responses can't be limited in time
+	r.Get("/events", streamEvents(sr))
+	if opts.listener == adminListener {
+		r.Mount("/debug", middleware.Profiler())
+	}
 
-	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		io.WriteString(w, "OK\n")
-	})
+	r.Group(func(r chi.Router) {
+		r.Use(timeout(writeTimeout))
 
-	r.Get("/healthz/ready", func(w http.ResponseWriter, r *http.Request) {
-		if sr.Ready() {
-			io.WriteString(w, "OK\n")
-		} else {
-			w.WriteHeader(552)
-			io.WriteString(w, "Not ready\n")
+		r.Get("/status", writeStatus(sr, opts.failureStatus))
+		r.Get("/status/namespaces/{namespace}", writeNamespaceStatus(sr, opts.failureStatus))
+
+		r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			if _, verbose := r.URL.Query()["verbose"]; verbose {
+				writeChecks(w, sr.LivenessChecks(), http.StatusInternalServerError, "Failure\n")
+				return
+			}
+
+			if sr.Alive() {
+				io.WriteString(w, "OK\n")
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
+				io.WriteString(w, "Failure\n")
+			}
+		})
+
+		r.Get("/healthz/ready", func(w http.ResponseWriter, r *http.Request) {
+			if _, verbose := r.URL.Query()["verbose"]; verbose {
+				writeChecks(w, sr.ReadinessChecks(), opts.notReadyStatus, "Not ready\n")
+				return
+			}
+
+			if sr.Ready() {
+				io.WriteString(w, "OK\n")
+			} else {
+				w.WriteHeader(opts.notReadyStatus)
+				io.WriteString(w, "Not ready\n")
+			}
+		})
+
+		r.Get("/services", writeServices(sr))
+
+		r.Get("/silences", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			encoder := json.NewEncoder(w)
+			err := encoder.Encode(sr.ListSilences())
+			if err != nil {
+				http.Error(w, "Error writing response", http.StatusInternalServerError)
+			}
+		})
+
+		r.Get("/ui", renderDashboard(sr))
+
+		r.Get("/version", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			encoder := json.NewEncoder(w)
+			err := encoder.Encode(version.ChcVer)
+			if err != nil {
+				http.Error(w, "Error writing response", http.StatusInternalServerError)
+			}
+		})
+
+		if opts.listener != publicListener {
+			adminRoutes(r, sr)
 		}
-	})
 
-	r.Get("/services", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		encoder := json.NewEncoder(w)
-		err := encoder.Encode(sr.State())
-		if err != nil {
-			http.Error(w, "Error writing response", http.StatusInternalServerError)
+		if opts.listener == adminListener {
+			r.Get("/config", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				encoder := json.NewEncoder(w)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(opts.config); err != nil {
+					http.Error(w, "Error writing response", http.StatusInternalServerError)
+				}
+			})
 		}
 	})
 
+	return r
+}
+
+// timeout answers 503 to the requests not served within the time limit. It replaces the
+// write timeout of the server, which would also end the streamed responses.
+func timeout(limit time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, limit, "Timeout\n")
+	}
+}
+
+// adminRoutes adds the mutating and metrics routes, which the public listener doesn't serve
+func adminRoutes(r chi.Router, sr StateReporter) {
 	r.Post("/services", func(w http.ResponseWriter, r *http.Request) {
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
@@ -124,14 +318,30 @@ func router(sr StateReporter, log *zap.Logger) http.Handler {
 		sr.Delete(service)
 	})
 
-	r.Get("/version", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		encoder := json.NewEncoder(w)
-		err := encoder.Encode(version.ChcVer)
+	r.Post("/silences", func(w http.ResponseWriter, r *http.Request) {
+		var silence checker.Silence
+		if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+			http.Error(w, "Invalid silence definition", http.StatusBadRequest)
+			return
+		}
+		silence, err := sr.AddSilence(silence)
 		if err != nil {
-			http.Error(w, "Error writing response", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(silence)
 	})
 
-	return r
+	r.Delete("/silences/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !sr.DeleteSilence(chi.URLParam(r, "id")) {
+			http.Error(w, "Silence not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Get("/metrics", writeMetrics(sr))
 }
@@ -1,24 +1,63 @@
 package server
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"wiley.com/healthcat/checker"
 )
 
 type testReporter struct {
-	healthy bool
-	ready   bool
-	state   checker.ClusterState
+	healthy  bool
+	ready    bool
+	state    checker.ClusterState
+	events   []checker.Event
+	lastID   *uint64
+	silences map[string]checker.Silence
+	stuck    []string
+	query    *checker.ServiceQuery
 }
 
 func (r testReporter) State() checker.ClusterState {
-	return checker.ClusterState{}
+	return r.state
+}
+
+func (r testReporter) Query(q checker.ServiceQuery) (checker.ClusterState, error) {
+	if q.Sort != "" && q.Sort != checker.SortByName && q.Sort != checker.SortByStatus {
+		return checker.ClusterState{}, fmt.Errorf("unknown sort order %q", q.Sort)
+	}
+	if r.query != nil {
+		*r.query = q
+	}
+	return r.state, nil
+}
+
+func (r testReporter) NamespaceStatus(namespace string) (checker.NamespaceStatus, bool) {
+	for _, ns := range r.state.Namespaces {
+		if ns.Name == namespace {
+			return ns, true
+		}
+	}
+	return checker.NamespaceStatus{}, false
 }
 
 func (r testReporter) Healthy() bool {
@@ -29,9 +68,69 @@ func (r testReporter) Ready() bool {
 	return r.ready
 }
 
+func (r testReporter) ReadinessChecks() []checker.Check {
+	if r.ready {
+		return []checker.Check{{Name: "discovery", OK: true}, {Name: "targets", OK: true}}
+	}
+	return []checker.Check{{Name: "discovery", OK: true}, {Name: "targets", Message: "1 of 2 targets not probed yet"}}
+}
+
+func (r testReporter) Alive() bool {
+	return len(r.stuck) == 0
+}
+
+func (r testReporter) LivenessChecks() []checker.Check {
+	checks := []checker.Check{{Name: "checker", OK: true}}
+	for _, name := range r.stuck {
+		checks = append(checks, checker.Check{Name: name, Message: "no heartbeat"})
+	}
+	return checks
+}
+
 func (r testReporter) Add(name, url string) {}
 func (r testReporter) Delete(url string)    {}
 
+func (r testReporter) Subscribe(filter checker.EventFilter) (<-chan checker.Event, func()) {
+	events := make(chan checker.Event, len(r.events))
+	for _, e := range r.events {
+		if filter == nil || filter(e) {
+			events <- e
+		}
+	}
+	close(events)
+	return events, func() {}
+}
+
+func (r testReporter) AddSilence(s checker.Silence) (checker.Silence, error) {
+	if s.Name == "" {
+		return checker.Silence{}, errors.New("no name")
+	}
+	s.ID = "id-" + s.Name
+	r.silences[s.ID] = s
+	return s, nil
+}
+
+func (r testReporter) DeleteSilence(id string) bool {
+	_, ok := r.silences[id]
+	delete(r.silences, id)
+	return ok
+}
+
+func (r testReporter) ListSilences() []checker.Silence {
+	silences := make([]checker.Silence, 0, len(r.silences))
+	for _, s := range r.silences {
+		silences = append(silences, s)
+	}
+	return silences
+}
+
+func (r testReporter) SubscribeFrom(lastID uint64, filter checker.EventFilter) (<-chan checker.Event, func()) {
+	if r.lastID != nil {
+		*r.lastID = lastID
+	}
+	return r.Subscribe(filter)
+}
+
 var Logger *zap.Logger
 
 func TestGetStatus(t *testing.T) {
@@ -50,7 +149,7 @@ func TestGetStatus(t *testing.T) {
 
 			reporter := testReporter{healthy: c.healthy}
 
-			server := router(reporter, Logger)
+			server := router(reporter, Logger, options{})
 			server.ServeHTTP(response, request)
 
 			got := response.Body.String()
@@ -68,7 +167,7 @@ func TestHealthz(t *testing.T) {
 	request := httptest.NewRequest("", "/healthz", nil)
 	response := httptest.NewRecorder()
 
-	server := router(testReporter{}, Logger)
+	server := router(testReporter{}, Logger, options{})
 	server.ServeHTTP(response, request)
 
 	statusGot := response.Result().StatusCode
@@ -86,23 +185,63 @@ func TestHealthz(t *testing.T) {
 	}
 }
 
-func TestReadiness(t *testing.T) {
+func TestLiveness(t *testing.T) {
 	cases := []struct {
 		name    string
-		ready   bool
+		url     string
+		stuck   []string
 		status  int
 		message string
 	}{
-		{"Ready", true, 200, "OK\n"},
-		{"NotReady", false, 552, "Not ready\n"},
+		{"Alive", "/healthz", nil, 200, "OK\n"},
+		{"Stuck", "/healthz", []string{"kubernetes"}, 500, "Failure\n"},
+		{"VerboseAlive", "/healthz?verbose", nil, 200, "[+]checker ok\nOK\n"},
+		{"VerboseStuck", "/healthz?verbose", []string{"kubernetes"}, 500,
+			"[+]checker ok\n[-]kubernetes failed: no heartbeat\nFailure\n"},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/healthz/ready", nil)
+			req := httptest.NewRequest(http.MethodGet, c.url, nil)
 			resp := httptest.NewRecorder()
 
-			server := router(testReporter{ready: c.ready}, Logger)
+			server := router(testReporter{stuck: c.stuck}, Logger, options{})
+			server.ServeHTTP(resp, req)
+
+			if want, got := c.status, resp.Result().StatusCode; want != got {
+				t.Errorf("Want status %d, got %d", want, got)
+			}
+
+			if want, got := c.message, resp.Body.String(); want != got {
+				t.Errorf("Want message %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	cases := []struct {
+		name           string
+		url            string
+		ready          bool
+		notReadyStatus int
+		status         int
+		message        string
+	}{
+		{"Ready", "/healthz/ready", true, 0, 200, "OK\n"},
+		{"NotReady", "/healthz/ready", false, 0, 552, "Not ready\n"},
+		{"NotReadyStatus", "/healthz/ready", false, 503, 503, "Not ready\n"},
+		{"VerboseReady", "/healthz/ready?verbose", true, 0, 200, "[+]discovery ok\n[+]targets ok\nOK\n"},
+		{"VerboseNotReady", "/healthz/ready?verbose", false, 0, 552,
+			"[+]discovery ok\n[-]targets failed: 1 of 2 targets not probed yet\nNot ready\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, c.url, nil)
+			resp := httptest.NewRecorder()
+
+			server := router(testReporter{ready: c.ready}, Logger, options{notReadyStatus: c.notReadyStatus})
 			server.ServeHTTP(resp, req)
 
 			if want, got := c.status, resp.Result().StatusCode; want != got {
@@ -132,7 +271,7 @@ func TestServices(t *testing.T) {
 			{Name: "s2", Healthy: false},
 		},
 	}
-	server := router(testReporter{state: state}, Logger)
+	server := router(testReporter{state: state}, Logger, options{})
 	server.ServeHTTP(resp, req)
 
 	decoder := json.NewDecoder(resp.Body)
@@ -142,6 +281,760 @@ func TestServices(t *testing.T) {
 	}
 }
 
+func TestServicesQuery(t *testing.T) {
+	state := checker.ClusterState{
+		Cluster: checker.Cluster{Name: "c1", Total: 2, Failed: 1},
+		Services: []checker.Service{
+			{Name: "s1", Namespace: "n1", Healthy: true, Status: checker.ServiceHealthy},
+			{Name: "s2", Namespace: "n1", Status: checker.ServiceFailed, Error: "Status 500"},
+		},
+		Continue:   "next",
+		Namespaces: []checker.NamespaceStatus{{Name: "n1", Status: checker.ClusterUnhealthy, Threshold: 100, Total: 2, Failed: 1}},
+	}
+
+	var query checker.ServiceQuery
+	req := httptest.NewRequest("", "/services?namespace=n1&status=failed,missing&label=app%3Dweb&prefix=s&sort=name&limit=2&continue=abc&fields=name,error", nil)
+	resp := httptest.NewRecorder()
+	server := router(testReporter{state: state, query: &query}, Logger, options{})
+	server.ServeHTTP(resp, req)
+
+	want := checker.ServiceQuery{
+		Namespace: "n1",
+		Statuses:  []checker.ServiceStatus{checker.ServiceFailed, checker.ServiceMissing},
+		Selector:  "app=web",
+		Prefix:    "s",
+		Sort:      checker.SortByName,
+		Limit:     2,
+		Continue:  "abc",
+	}
+	if !reflect.DeepEqual(query, want) {
+		t.Errorf("got query %+v, want %+v", query, want)
+	}
+	wantBody := `{"cluster":{"name":"c1","healthy":false,"status":"","total":2,"failed":1,"muted":0,"missing":0,"impacted":0},` +
+		`"services":[{"name":"s1"},{"error":"Status 500","name":"s2"}],"continue":"next",` +
+		`"namespaces":[{"name":"n1","healthy":false,"status":"` + string(checker.ClusterUnhealthy) + `","threshold":100,"total":2,"failed":1,"muted":0,"impacted":0}]}` + "\n"
+	if got := resp.Body.String(); got != wantBody {
+		t.Errorf("got %s, want %s", got, wantBody)
+	}
+
+	for _, invalid := range []string{"status=unknown", "limit=-1", "limit=x", "sort=age"} {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest("", "/services?"+invalid, nil))
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", invalid, resp.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	events := []checker.Event{
+		{ID: 1, Type: checker.TargetAdded, Target: "s1.ns1", Namespace: "ns1"},
+		{ID: 2, Type: checker.TargetAdded, Target: "s2.ns2", Namespace: "ns2"},
+	}
+
+	cases := []struct {
+		name        string
+		url         string
+		lastEventID string
+		status      int
+		ids         []string
+		resumedFrom uint64
+	}{
+		{"All", "/events", "", http.StatusOK, []string{"1", "2"}, 0},
+		{"Namespace", "/events?namespace=ns2", "", http.StatusOK, []string{"2"}, 0},
+		{"Resume", "/events", "1", http.StatusOK, []string{"1", "2"}, 1},
+		{"InvalidLastEventID", "/events", "abc", http.StatusBadRequest, nil, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, c.url, nil)
+			if c.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", c.lastEventID)
+			}
+			resp := httptest.NewRecorder()
+
+			var lastID uint64
+			server := router(testReporter{events: events, lastID: &lastID}, Logger, options{})
+			server.ServeHTTP(resp, req)
+
+			if want, got := c.status, resp.Result().StatusCode; want != got {
+				t.Fatalf("Want status %d, got %d", want, got)
+			}
+			if c.status != http.StatusOK {
+				return
+			}
+			if want, got := "text/event-stream", resp.Header().Get("Content-Type"); want != got {
+				t.Errorf("Want content type %q, got %q", want, got)
+			}
+
+			var ids []string
+			for _, line := range strings.Split(resp.Body.String(), "\n") {
+				if strings.HasPrefix(line, "id: ") {
+					ids = append(ids, strings.TrimPrefix(line, "id: "))
+				}
+			}
+			if !reflect.DeepEqual(c.ids, ids) {
+				t.Errorf("Want event IDs %v, got %v", c.ids, ids)
+			}
+			if want, got := c.resumedFrom, lastID; want != got {
+				t.Errorf("Want resume from %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	state := checker.ClusterState{
+		Cluster: checker.Cluster{Name: "c1", Healthy: false, Total: 3, Failed: 1},
+		Services: []checker.Service{
+			{Name: "web.shop", Namespace: "shop", Healthy: true, Status: checker.ServiceHealthy, LastCheck: time.Now()},
+			{Name: "db.shop", Namespace: "shop", Healthy: false, Status: checker.ServiceFailed, LastCheck: time.Now(), Error: "Status 500"},
+			{Name: "auth.platform", Namespace: "platform", Status: checker.ServiceMissing, Error: "service is missing"},
+		},
+	}
+
+	cases := []struct {
+		name    string
+		url     string
+		want    []string
+		notWant []string
+	}{
+		{"All", "/ui", []string{"c1", "web.shop", "db.shop", "auth.platform", "Status 500", "missing"}, nil},
+		{"Filter", "/ui?filter=shop", []string{"web.shop", "db.shop"}, []string{"auth.platform"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, c.url, nil)
+			resp := httptest.NewRecorder()
+
+			server := router(testReporter{state: state}, Logger, options{})
+			server.ServeHTTP(resp, req)
+
+			if want, got := http.StatusOK, resp.Result().StatusCode; want != got {
+				t.Fatalf("Want status %d, got %d", want, got)
+			}
+			body := resp.Body.String()
+			for _, s := range c.want {
+				if !strings.Contains(body, s) {
+					t.Errorf("Want %q in the dashboard", s)
+				}
+			}
+			for _, s := range c.notWant {
+				if strings.Contains(body, s) {
+					t.Errorf("Want no %q in the dashboard", s)
+				}
+			}
+		})
+	}
+}
+
+func TestSilences(t *testing.T) {
+	reporter := testReporter{silences: make(map[string]checker.Silence)}
+	server := router(reporter, Logger, options{})
+
+	cases := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+	}{
+		{"Add", http.MethodPost, "/silences", `{"name":"s1","comment":"deploy"}`, http.StatusCreated},
+		{"AddInvalidJSON", http.MethodPost, "/silences", `{`, http.StatusBadRequest},
+		{"AddInvalid", http.MethodPost, "/silences", `{"comment":"deploy"}`, http.StatusBadRequest},
+		{"List", http.MethodGet, "/silences", "", http.StatusOK},
+		{"Delete", http.MethodDelete, "/silences/id-s1", "", http.StatusNoContent},
+		{"DeleteMissing", http.MethodDelete, "/silences/id-s1", "", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.url, strings.NewReader(c.body))
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			if want, got := c.status, resp.Result().StatusCode; want != got {
+				t.Errorf("Want status %d, got %d", want, got)
+			}
+		})
+	}
+}
+
 func init() {
 	Logger, _ = zap.NewDevelopment()
 }
+
+func TestMetrics(t *testing.T) {
+	expiry := time.Unix(1900000000, 0)
+	state := checker.ClusterState{
+		Cluster: checker.Cluster{Name: "c1", Healthy: false, Total: 2, Failed: 1},
+		Services: []checker.Service{
+			{Name: "web.shop", Namespace: "shop", Healthy: true, Status: checker.ServiceDegraded, CertExpiry: &expiry},
+			{Name: "db.shop", Namespace: "shop", Healthy: false, Status: checker.ServiceFailed, ErrorClass: checker.ErrorHTTP5xx},
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	resp := httptest.NewRecorder()
+	router(testReporter{state: state}, Logger, options{}).ServeHTTP(resp, req)
+
+	if want, got := http.StatusOK, resp.Result().StatusCode; want != got {
+		t.Fatalf("Want status %d, got %d", want, got)
+	}
+	body := resp.Body.String()
+	for _, s := range []string{
+		`healthcat_cluster_healthy{cluster="c1"} 0`,
+		`healthcat_cluster_services{cluster="c1"} 2`,
+		`healthcat_cluster_failed_services{cluster="c1"} 1`,
+		`healthcat_service_healthy{cluster="c1",service="db.shop",namespace="shop"} 0`,
+		`healthcat_service_healthy{cluster="c1",service="web.shop",namespace="shop"} 1`,
+		`healthcat_service_status{cluster="c1",service="db.shop",namespace="shop",status="failed"} 1`,
+		`healthcat_service_status{cluster="c1",service="db.shop",namespace="shop",status="healthy"} 0`,
+		`healthcat_service_status{cluster="c1",service="web.shop",namespace="shop",status="degraded"} 1`,
+		`healthcat_service_status{cluster="c1",service="web.shop",namespace="shop",status="failed"} 0`,
+		`healthcat_service_error{cluster="c1",service="db.shop",namespace="shop",class="http-5xx"} 1`,
+		`healthcat_service_cert_expiry_timestamp_seconds{cluster="c1",service="web.shop",namespace="shop"} 1.9e+09`,
+	} {
+		if !strings.Contains(body, s+"\n") {
+			t.Errorf("Want %q in the metrics, got\n%s", s, body)
+		}
+	}
+	if strings.Contains(body, `healthcat_service_cert_expiry_timestamp_seconds{cluster="c1",service="db.shop"`) {
+		t.Error("Want no certificate expiry of a service without TLS")
+	}
+}
+
+func TestStatusFormats(t *testing.T) {
+	lastCheck := time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)
+	reporter := testReporter{
+		state: checker.ClusterState{
+			Cluster: checker.Cluster{
+				Name:   "abc",
+				Status: checker.ClusterUnhealthy,
+				Reason: "1 of 2 services failed",
+				Total:  2,
+				Failed: 1,
+			},
+			Services: []checker.Service{
+				{Name: "auth.platform", Namespace: "platform", Status: checker.ServiceFailed, Error: "Status 500", LastCheck: lastCheck},
+				{Name: "web.shop", Namespace: "shop", Healthy: true, Status: checker.ServiceDegraded, Warning: "expiring", LastCheck: lastCheck},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"Plain text", "", "", http.StatusServiceUnavailable, "", "Failure\n"},
+		{"Any", "", "*/*", http.StatusServiceUnavailable, "", "Failure\n"},
+		{
+			"Verbose",
+			"?verbose",
+			"text/plain",
+			http.StatusServiceUnavailable,
+			"",
+			"[-]auth.platform failed: Status 500\n[+]web.shop ok\nFailure\n",
+		},
+		{
+			"JSON",
+			"",
+			"application/json",
+			http.StatusServiceUnavailable,
+			"application/json",
+			`{"name":"abc","healthy":false,"status":"unhealthy","reason":"1 of 2 services failed","total":2,"failed":1,"muted":0,"missing":0,"impacted":0}` + "\n",
+		},
+		{
+			"Health JSON",
+			"",
+			"application/json;q=0.5, application/health+json",
+			http.StatusServiceUnavailable,
+			"application/health+json",
+			`{"status":"fail","serviceId":"abc","description":"Kubernetes cluster health","output":"1 of 2 services failed","checks":{` +
+				`"auth.platform":[{"componentId":"platform","componentType":"service","status":"fail","output":"Status 500","time":"2030-01-02T15:04:05Z"}],` +
+				`"web.shop":[{"componentId":"shop","componentType":"service","status":"warn","output":"expiring","time":"2030-01-02T15:04:05Z"}]}}` + "\n",
+		},
+		{"Not acceptable", "", "application/xml", http.StatusNotAcceptable, "", "Unsupported media type, want one of text/plain, application/json, application/health+json\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/status"+tt.query, nil)
+			if tt.accept != "" {
+				request.Header.Set("Accept", tt.accept)
+			}
+			response := httptest.NewRecorder()
+
+			server := router(reporter, Logger, options{failureStatus: http.StatusServiceUnavailable})
+			server.ServeHTTP(response, request)
+
+			if response.Code != tt.status {
+				t.Errorf("got status %d, want %d", response.Code, tt.status)
+			}
+			if got := response.Header().Get("Content-Type"); tt.contentType != "" && got != tt.contentType {
+				t.Errorf("got content type %q, want %q", got, tt.contentType)
+			}
+			if got := response.Body.String(); got != tt.body {
+				t.Errorf("got %s, want %s", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestNamespaceStatus(t *testing.T) {
+	reporter := testReporter{
+		state: checker.ClusterState{
+			Namespaces: []checker.NamespaceStatus{
+				{Name: "platform", Healthy: true, Status: checker.ClusterHealthy, Threshold: 100, Total: 1},
+				{Name: "shop", Status: checker.ClusterUnhealthy, Reason: "1 of 2 services failed", Threshold: 100, Total: 2, Failed: 1},
+			},
+		},
+	}
+
+	tests := []struct {
+		namespace string
+		accept    string
+		status    int
+		body      string
+	}{
+		{"platform", "", http.StatusOK, "OK\n"},
+		{"shop", "", http.StatusServiceUnavailable, "Failure\n"},
+		{
+			"shop",
+			"application/json",
+			http.StatusServiceUnavailable,
+			`{"name":"shop","healthy":false,"status":"unhealthy","reason":"1 of 2 services failed","threshold":100,"total":2,"failed":1,"muted":0,"impacted":0}` + "\n",
+		},
+		{"missing", "", http.StatusNotFound, "Namespace not found\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.namespace+tt.accept, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/status/namespaces/"+tt.namespace, nil)
+			if tt.accept != "" {
+				request.Header.Set("Accept", tt.accept)
+			}
+			response := httptest.NewRecorder()
+
+			server := router(reporter, Logger, options{failureStatus: http.StatusServiceUnavailable})
+			server.ServeHTTP(response, request)
+
+			if response.Code != tt.status || response.Body.String() != tt.body {
+				t.Errorf("got %d %q, want %d %q", response.Code, response.Body.String(), tt.status, tt.body)
+			}
+		})
+	}
+}
+
+type testReviewer map[string][]string
+
+func (r testReviewer) Review(token string) (string, []string, error) {
+	groups, ok := r[token]
+	if !ok {
+		return "", nil, errors.New("token not authenticated")
+	}
+	return "system:serviceaccount:ops:" + token, groups, nil
+}
+
+func TestAuthorization(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	tokens := "# token,name,role\nt0k3n,alice,admin\n\nr34d,bob,read-only\n"
+	if err := ioutil.WriteFile(path, []byte(tokens), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile, err := LoadTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, audit := observer.New(zap.InfoLevel)
+	authenticators := []Authenticator{
+		&CertificateAuthenticator{Admins: []string{"ops"}},
+		tokenFile,
+		&TokenReviewAuthenticator{Reviewer: testReviewer{"deployer": {"ops"}, "viewer": nil}, Admins: []string{"ops"}},
+	}
+	server := router(testReporter{silences: make(map[string]checker.Silence)}, zap.New(core), options{authenticators: authenticators})
+
+	tests := []struct {
+		name     string
+		method   string
+		token    string
+		cert     *pkix.Name
+		status   int
+		identity string
+	}{
+		{"ReadAnonymous", http.MethodGet, "", nil, http.StatusOK, ""},
+		{"Anonymous", http.MethodPost, "", nil, http.StatusUnauthorized, ""},
+		{"UnknownToken", http.MethodPost, "wrong", nil, http.StatusUnauthorized, ""},
+		{"ReadOnlyToken", http.MethodPost, "r34d", nil, http.StatusForbidden, "bob"},
+		{"AdminToken", http.MethodPost, "t0k3n", nil, http.StatusOK, "alice"},
+		{"ReviewedReadOnly", http.MethodPost, "viewer", nil, http.StatusForbidden, "system:serviceaccount:ops:viewer"},
+		{"ReviewedAdmin", http.MethodPost, "deployer", nil, http.StatusOK, "system:serviceaccount:ops:deployer"},
+		{"ReadOnlyCertificate", http.MethodPost, "", &pkix.Name{CommonName: "dev"}, http.StatusForbidden, "dev"},
+		{"AdminCertificate", http.MethodPost, "", &pkix.Name{CommonName: "ci", Organization: []string{"ops"}}, http.StatusOK, "ci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit.TakeAll()
+			request := httptest.NewRequest(tt.method, "/services", strings.NewReader("http://web.shop/healthz"))
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.cert != nil {
+				request.TLS = &tls.ConnectionState{
+					VerifiedChains: [][]*x509.Certificate{{{Subject: *tt.cert}}},
+				}
+			}
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+
+			if response.Code != tt.status {
+				t.Errorf("got status %d, want %d", response.Code, tt.status)
+			}
+
+			entries := audit.FilterMessage("API mutation").AllUntimed()
+			if tt.method == http.MethodGet {
+				if len(entries) != 0 {
+					t.Errorf("got %d audit entries for a read, want none", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d audit entries, want 1", len(entries))
+			}
+			fields := entries[0].ContextMap()
+			if fields["status"] != int64(tt.status) {
+				t.Errorf("got audited status %v, want %d", fields["status"], tt.status)
+			}
+			if identity, _ := fields["identity"].(string); identity != tt.identity {
+				t.Errorf("got audited identity %q, want %q", identity, tt.identity)
+			}
+		})
+	}
+}
+
+func TestAuthorizationAdminReads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := ioutil.WriteFile(path, []byte("t0k3n,alice,admin\nr34d,bob,read-only\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile, err := LoadTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, audit := observer.New(zap.InfoLevel)
+	server := router(testReporter{}, zap.New(core), options{
+		authenticators: []Authenticator{tokenFile},
+		listener:       adminListener,
+		config:         map[string]interface{}{"interval": "10s"},
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{"ConfigAnonymous", "/config", "", http.StatusUnauthorized},
+		{"ConfigReadOnly", "/config", "r34d", http.StatusForbidden},
+		{"ConfigAdmin", "/config", "t0k3n", http.StatusOK},
+		{"DebugAnonymous", "/debug/pprof/", "", http.StatusUnauthorized},
+		{"DebugReadOnly", "/debug/pprof/", "r34d", http.StatusForbidden},
+		{"DebugAdmin", "/debug/pprof/", "t0k3n", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit.TakeAll()
+			request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+
+			if response.Code != tt.status {
+				t.Errorf("got status %d, want %d", response.Code, tt.status)
+			}
+			if entries := audit.FilterMessage("API admin read").AllUntimed(); len(entries) != 1 {
+				t.Errorf("got %d audit entries, want 1", len(entries))
+			}
+		})
+	}
+}
+
+func TestAuditWithoutAuthentication(t *testing.T) {
+	core, audit := observer.New(zap.InfoLevel)
+	server := router(testReporter{silences: make(map[string]checker.Silence)}, zap.New(core), options{})
+
+	request := httptest.NewRequest(http.MethodPost, "/services", strings.NewReader("http://web.shop/healthz"))
+	request.RemoteAddr = "10.0.0.7:41234"
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", response.Code, http.StatusOK)
+	}
+	entries := audit.FilterMessage("API mutation").AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("got %d audit entries, want 1", len(entries))
+	}
+	if identity := entries[0].ContextMap()["identity"]; identity != "10.0.0.7:41234" {
+		t.Errorf("got audited identity %v, want the remote address", identity)
+	}
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens string
+		err    bool
+	}{
+		{"Valid", "t0k3n,alice,admin\n", false},
+		{"MissingRole", "t0k3n,alice\n", true},
+		{"UnknownRole", "t0k3n,alice,root\n", true},
+		{"EmptyToken", ",alice,admin\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.csv")
+			if err := ioutil.WriteFile(path, []byte(tt.tokens), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadTokenFile(path); (err != nil) != tt.err {
+				t.Errorf("got error %v, want error %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// testCertificate writes a certificate signed by the parent, or a self-signed one, and its key as PEM files
+func testCertificate(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	if version, err := ParseTLSVersion("1.3"); err != nil || version != tls.VersionTLS13 {
+		t.Errorf("got %x %v, want TLS 1.3", version, err)
+	}
+	if _, err := ParseTLSVersion("1.4"); err == nil {
+		t.Error("got no error for an unsupported version")
+	}
+}
+
+func TestTLSServing(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := testCertificate(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "healthcat-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	testCertificate(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "healthcat"},
+		DNSNames:     []string{"example.com"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	testCertificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "ci", Organization: []string{"ops"}},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		require    bool
+		clientCert bool
+		status     int
+		handshake  bool
+	}{
+		{"ClientCertificate", false, true, http.StatusOK, true},
+		{"NoClientCertificate", false, false, http.StatusUnauthorized, true},
+		{"RequiredClientCertificate", true, true, http.StatusOK, true},
+		{"MissingRequiredClientCertificate", true, false, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsOptions := &TLSOptions{
+				CertFile:          filepath.Join(dir, "server.crt"),
+				KeyFile:           filepath.Join(dir, "server.key"),
+				ClientCAFile:      filepath.Join(dir, "ca.crt"),
+				RequireClientCert: tt.require,
+				MinVersion:        tls.VersionTLS12,
+			}
+			config, err := tlsOptions.Config(Logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			authenticators := []Authenticator{&CertificateAuthenticator{Admins: []string{"ops"}}}
+			server := httptest.NewUnstartedServer(router(testReporter{}, Logger, options{authenticators: authenticators}))
+			server.TLS = config
+			server.StartTLS()
+			defer server.Close()
+
+			roots := x509.NewCertPool()
+			roots.AddCert(ca)
+			clientConfig := &tls.Config{RootCAs: roots, ServerName: "example.com"}
+			if tt.clientCert {
+				clientConfig.Certificates = []tls.Certificate{clientCert}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+
+			resp, err := client.Post(server.URL+"/services", "text/plain", strings.NewReader("http://web.shop/healthz"))
+			if !tt.handshake {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("got no error without the required client certificate")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	template := func(serial int64) *x509.Certificate {
+		return &x509.Certificate{SerialNumber: big.NewInt(serial), Subject: pkix.Name{CommonName: "healthcat"}}
+	}
+	testCertificate(t, dir, "server", template(1), nil, nil)
+
+	reloader := &certReloader{
+		certFile: filepath.Join(dir, "server.crt"),
+		keyFile:  filepath.Join(dir, "server.key"),
+		logger:   Logger.Sugar(),
+	}
+	if err := reloader.load(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	serial := func() int64 {
+		cert, _ := reloader.getCertificate(nil)
+		parsed, _ := x509.ParseCertificate(cert.Certificate[0])
+		return parsed.SerialNumber.Int64()
+	}
+
+	testCertificate(t, dir, "server", template(2), nil, nil)
+	if got := serial(); got != 1 {
+		t.Errorf("got certificate %d within the reload interval, want 1", got)
+	}
+
+	reloader.loadedAt = reloader.loadedAt.Add(-certReloadInterval)
+	if got := serial(); got != 2 {
+		t.Errorf("got certificate %d after rotation, want 2", got)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "server.key"), []byte("invalid"), 0600)
+	reloader.loadedAt = reloader.loadedAt.Add(-certReloadInterval)
+	if got := serial(); got != 2 {
+		t.Errorf("got certificate %d after a failed reload, want the previous one 2", got)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	reporter := testReporter{silences: make(map[string]checker.Silence)}
+	config := map[string]interface{}{"port": "8080"}
+
+	tests := []struct {
+		listener listener
+		method   string
+		url      string
+		status   int
+	}{
+		{combinedListener, http.MethodGet, "/status", http.StatusInternalServerError},
+		{combinedListener, http.MethodPost, "/services", http.StatusOK},
+		{combinedListener, http.MethodGet, "/metrics", http.StatusOK},
+		{combinedListener, http.MethodGet, "/debug/pprof/", http.StatusNotFound},
+		{combinedListener, http.MethodGet, "/config", http.StatusNotFound},
+		{publicListener, http.MethodGet, "/status", http.StatusInternalServerError},
+		{publicListener, http.MethodGet, "/services", http.StatusOK},
+		{publicListener, http.MethodPost, "/services", http.StatusMethodNotAllowed},
+		{publicListener, http.MethodDelete, "/silences/id-s1", http.StatusNotFound},
+		{publicListener, http.MethodGet, "/metrics", http.StatusNotFound},
+		{publicListener, http.MethodGet, "/debug/pprof/", http.StatusNotFound},
+		{publicListener, http.MethodGet, "/config", http.StatusNotFound},
+		{adminListener, http.MethodGet, "/status", http.StatusInternalServerError},
+		{adminListener, http.MethodPost, "/services", http.StatusOK},
+		{adminListener, http.MethodGet, "/metrics", http.StatusOK},
+		{adminListener, http.MethodGet, "/debug/pprof/", http.StatusOK},
+		{adminListener, http.MethodGet, "/config", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d%s%s", tt.listener, tt.method, tt.url), func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.url, strings.NewReader("http://web.shop/healthz"))
+			response := httptest.NewRecorder()
+
+			server := router(reporter, Logger, options{listener: tt.listener, config: config})
+			server.ServeHTTP(response, request)
+
+			if response.Code != tt.status {
+				t.Errorf("got status %d, want %d", response.Code, tt.status)
+			}
+			if tt.url == "/config" && tt.status == http.StatusOK {
+				var got map[string]interface{}
+				if err := json.Unmarshal(response.Body.Bytes(), &got); err != nil || !reflect.DeepEqual(got, config) {
+					t.Errorf("got config %s, want %v", response.Body.String(), config)
+				}
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+
+	response := httptest.NewRecorder()
+	timeout(10*time.Millisecond)(slow).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if response.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", response.Code, http.StatusServiceUnavailable)
+	}
+}
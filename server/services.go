@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"wiley.com/healthcat/checker"
+)
+
+// projectedState is the cluster state with only the requested fields of the services
+type projectedState struct {
+	Cluster    checker.Cluster              `json:"cluster"`
+	Services   []map[string]json.RawMessage `json:"services"`
+	Continue   string                       `json:"continue,omitempty"`
+	Namespaces []checker.NamespaceStatus    `json:"namespaces,omitempty"`
+}
+
+// parseServiceQuery reads the service query from the query parameters:
+// namespace, status (comma separated), label (selector), prefix, sort, limit and continue.
+// The namespaces are only summarized on the first page.
+func parseServiceQuery(values url.Values) (checker.ServiceQuery, error) {
+	q := checker.ServiceQuery{
+		Namespace:  values.Get("namespace"),
+		Selector:   values.Get("label"),
+		Prefix:     values.Get("prefix"),
+		Sort:       checker.ServiceSort(values.Get("sort")),
+		Continue:   values.Get("continue"),
+		Namespaces: values.Get("continue") == "",
+	}
+
+	var err error
+	if q.Statuses, err = checker.ParseServiceStatuses(values.Get("status")); err != nil {
+		return q, err
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	return q, nil
+}
+
+// project keeps only the given JSON fields of the services
+func project(state *checker.ClusterState, fields []string) (*projectedState, error) {
+	projected := &projectedState{
+		Cluster:    state.Cluster,
+		Services:   make([]map[string]json.RawMessage, 0, len(state.Services)),
+		Continue:   state.Continue,
+		Namespaces: state.Namespaces,
+	}
+	for i := range state.Services {
+		data, err := json.Marshal(&state.Services[i])
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+
+		svc := make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := all[field]; ok {
+				svc[field] = value
+			}
+		}
+		projected.Services = append(projected.Services, svc)
+	}
+	return projected, nil
+}
+
+// writeServices lists the services selected by the query parameters.
+// The fields parameter keeps only the given comma separated fields of the services.
+func writeServices(sr StateReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseServiceQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		state, err := sr.Query(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var response interface{} = state
+		if fields := r.URL.Query().Get("fields"); fields != "" {
+			if response, err = project(&state, strings.Split(fields, ",")); err != nil {
+				http.Error(w, "Error writing response", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		err = encoder.Encode(response)
+		if err != nil {
+			http.Error(w, "Error writing response", http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"wiley.com/healthcat/checker"
+)
+
+// Media types of the cluster status
+const (
+	textStatus       = "text/plain"
+	jsonStatus       = "application/json"
+	healthJSONStatus = "application/health+json"
+)
+
+// statusTypes are the media types of the cluster status, the default one first
+var statusTypes = []string{textStatus, jsonStatus, healthJSONStatus}
+
+// healthJSON is the cluster status in the application/health+json format
+type healthJSON struct {
+	Status      string                       `json:"status"`
+	ServiceID   string                       `json:"serviceId,omitempty"`
+	Description string                       `json:"description,omitempty"`
+	Output      string                       `json:"output,omitempty"`
+	Checks      map[string][]healthJSONCheck `json:"checks"`
+}
+
+// healthJSONCheck is the status of a service in the application/health+json format
+type healthJSONCheck struct {
+	ComponentID   string     `json:"componentId,omitempty"`
+	ComponentType string     `json:"componentType"`
+	Status        string     `json:"status"`
+	Output        string     `json:"output,omitempty"`
+	Time          *time.Time `json:"time,omitempty"`
+}
+
+// negotiate picks the offered media type the client accepts with the highest quality,
+// the first offered one if the client accepts any or sends no Accept header,
+// and an empty string if the client accepts none
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		for _, offer := range offers {
+			if q > bestQ && matchMediaType(mediaType, offer) {
+				best, bestQ = offer, q
+				break
+			}
+		}
+	}
+	return best
+}
+
+// matchMediaType reports whether the accepted media type, possibly a wildcard, matches the offer
+func matchMediaType(accepted, offer string) bool {
+	switch {
+	case accepted == "*/*", accepted == offer:
+		return true
+	case strings.HasSuffix(accepted, "/*"):
+		return strings.HasPrefix(offer, strings.TrimSuffix(accepted, "*"))
+	default:
+		return false
+	}
+}
+
+// serviceHealthStatus maps the health status of a service to the application/health+json one
+func serviceHealthStatus(svc *checker.Service) string {
+	switch {
+	case svc.Healthy && (svc.Status == checker.ServiceDegraded || svc.Status == checker.ServiceFlapping):
+		return "warn"
+	case svc.Healthy:
+		return "pass"
+	default:
+		return "fail"
+	}
+}
+
+// clusterHealthStatus maps the cluster status to the application/health+json one
+func clusterHealthStatus(cluster *checker.Cluster) string {
+	switch cluster.Status {
+	case checker.ClusterHealthy:
+		return "pass"
+	case checker.ClusterUnknown:
+		return "warn"
+	default:
+		return "fail"
+	}
+}
+
+// newHealthJSON describes the cluster state in the application/health+json format
+func newHealthJSON(state *checker.ClusterState) *healthJSON {
+	status := &healthJSON{
+		Status:      clusterHealthStatus(&state.Cluster),
+		ServiceID:   state.Cluster.Name,
+		Description: "Kubernetes cluster health",
+		Output:      state.Cluster.Reason,
+		Checks:      make(map[string][]healthJSONCheck, len(state.Services)),
+	}
+	for i := range state.Services {
+		svc := &state.Services[i]
+		check := healthJSONCheck{
+			ComponentID:   svc.Namespace,
+			ComponentType: "service",
+			Status:        serviceHealthStatus(svc),
+			Output:        svc.Error,
+		}
+		if check.Output == "" {
+			check.Output = svc.Warning
+		}
+		if !svc.LastCheck.IsZero() {
+			check.Time = &svc.LastCheck
+		}
+		status.Checks[svc.Name] = []healthJSONCheck{check}
+	}
+	return status
+}
+
+// serviceChecks lists the services as checks, e.g. for the verbose cluster status
+func serviceChecks(services []checker.Service) []checker.Check {
+	checks := make([]checker.Check, 0, len(services))
+	for _, svc := range services {
+		check := checker.Check{Name: svc.Name, OK: svc.Healthy}
+		if !svc.Healthy {
+			check.Message = svc.Error
+			if check.Message == "" {
+				check.Message = string(svc.Status)
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// writeStatus reports the cluster status in the media type negotiated with the client:
+// OK or Failure in plain text, the cluster summary in JSON, or the status of each service
+// in application/health+json. The verbose plain text lists the services like /healthz.
+func writeStatus(sr StateReporter, failureStatus int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept")
+		mediaType := negotiate(r.Header.Get("Accept"), statusTypes)
+		_, verbose := r.URL.Query()["verbose"]
+
+		if mediaType == "" {
+			http.Error(w, "Unsupported media type, want one of "+strings.Join(statusTypes, ", "), http.StatusNotAcceptable)
+			return
+		}
+		if mediaType == textStatus && !verbose {
+			if sr.Healthy() {
+				w.WriteHeader(http.StatusOK)
+				io.WriteString(w, "OK\n")
+			} else {
+				w.WriteHeader(failureStatus)
+				io.WriteString(w, "Failure\n")
+			}
+			return
+		}
+
+		state := sr.State()
+		code := http.StatusOK
+		if !state.Cluster.Healthy {
+			code = failureStatus
+		}
+
+		switch mediaType {
+		case jsonStatus:
+			w.Header().Set("Content-Type", jsonStatus)
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(state.Cluster)
+		case healthJSONStatus:
+			w.Header().Set("Content-Type", healthJSONStatus)
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(newHealthJSON(&state))
+		default:
+			checks := serviceChecks(state.Services)
+			if state.Cluster.Healthy {
+				writeCheckList(w, checks, http.StatusOK, "OK\n")
+			} else {
+				writeCheckList(w, checks, failureStatus, "Failure\n")
+			}
+		}
+	}
+}
+
+// writeNamespaceStatus reports the status of a namespace in plain text or in JSON,
+// like the cluster status
+func writeNamespaceStatus(sr StateReporter, failureStatus int) http.HandlerFunc {
+	offers := []string{textStatus, jsonStatus}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept")
+		mediaType := negotiate(r.Header.Get("Accept"), offers)
+		if mediaType == "" {
+			http.Error(w, "Unsupported media type, want one of "+strings.Join(offers, ", "), http.StatusNotAcceptable)
+			return
+		}
+
+		ns, ok := sr.NamespaceStatus(chi.URLParam(r, "namespace"))
+		if !ok {
+			http.Error(w, "Namespace not found", http.StatusNotFound)
+			return
+		}
+		code := http.StatusOK
+		if !ns.Healthy {
+			code = failureStatus
+		}
+
+		if mediaType == jsonStatus {
+			w.Header().Set("Content-Type", jsonStatus)
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(ns)
+			return
+		}
+		w.WriteHeader(code)
+		if ns.Healthy {
+			io.WriteString(w, "OK\n")
+		} else {
+			io.WriteString(w, "Failure\n")
+		}
+	}
+}
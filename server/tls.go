@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// certReloadInterval is the minimum time between two reads of the server certificate files
+const certReloadInterval = time.Minute
+
+// TLSOptions configures the TLS serving of the API
+type TLSOptions struct {
+	CertFile          string // Path to the PEM encoded server certificate
+	KeyFile           string // Path to the PEM encoded server certificate key
+	ClientCAFile      string // Path to the CA bundle verifying the client certificates, if set
+	RequireClientCert bool   // Rejects the clients without a certificate verified by the client CA
+	MinVersion        uint16 // Minimum TLS version, TLS 1.2 if 0
+}
+
+// tlsVersions are the TLS versions by name
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version, e.g. 1.2
+func ParseTLSVersion(s string) (uint16, error) {
+	version, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q, want 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	return version, nil
+}
+
+// Config builds the server TLS configuration. The certificate is loaded at once
+// and then reloaded when its files change, e.g. on rotation.
+func (o *TLSOptions) Config(logger *zap.Logger) (*tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("TLS requires both a certificate and a key")
+	}
+
+	reloader := &certReloader{certFile: o.CertFile, keyFile: o.KeyFile, logger: logger.Sugar()}
+	if err := reloader.load(time.Now()); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		GetCertificate: reloader.getCertificate,
+		MinVersion:     o.MinVersion,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if o.ClientCAFile != "" {
+		ca, err := ioutil.ReadFile(o.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in the client CA file %s", o.ClientCAFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if o.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if o.RequireClientCert {
+		return nil, errors.New("requiring client certificates needs a client CA")
+	}
+	return config, nil
+}
+
+// certReloader serves the server certificate, reloading it when its files change.
+// If they can't be reloaded, the previous certificate is kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *zap.SugaredLogger
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certPEM  []byte
+	keyPEM   []byte
+	loadedAt time.Time
+}
+
+// load reads the certificate files, parsing them if they have changed
+func (r *certReloader) load(now time.Time) error {
+	r.loadedAt = now
+
+	certPEM, err := ioutil.ReadFile(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM) {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("loading server certificate: %v", err)
+	}
+	if r.cert != nil {
+		r.logger.Infof("Server certificate %s reloaded", r.certFile)
+	}
+	r.cert, r.certPEM, r.keyPEM = &cert, certPEM, keyPEM
+	return nil
+}
+
+// getCertificate returns the certificate, checking its files at most once per reload interval
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.loadedAt) >= certReloadInterval {
+		if err := r.load(now); err != nil {
+			r.logger.Warnf("Keeping the previous server certificate: %v", err)
+		}
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"wiley.com/healthcat/checker"
+)
+
+// dashboardRefresh is the number of seconds between automatic dashboard reloads
+const dashboardRefresh = 10
+
+//go:embed templates/*.html
+var templates embed.FS
+
+var dashboardTemplate = template.Must(template.New("dashboard.html").
+	Funcs(template.FuncMap{
+		"age": func(t time.Time) time.Duration {
+			return time.Since(t).Round(time.Second)
+		},
+		"join": strings.Join,
+	}).
+	ParseFS(templates, "templates/dashboard.html"))
+
+// dashboardNamespace groups the services of a namespace
+type dashboardNamespace struct {
+	Name     string
+	Services []checker.Service
+}
+
+// dashboard is the data rendered by the dashboard template
+type dashboard struct {
+	Cluster    checker.Cluster
+	Namespaces []dashboardNamespace
+	Filter     string
+	Refresh    int
+	Now        time.Time
+}
+
+// renderDashboard serves the HTML dashboard with the services grouped by namespace.
+// The "filter" query parameter limits the services to those with the given
+// substring in the name.
+func renderDashboard(sr StateReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := sr.State()
+		filter := strings.TrimSpace(r.URL.Query().Get("filter"))
+
+		groups := make(map[string][]checker.Service)
+		for _, svc := range state.Services {
+			if filter != "" && !strings.Contains(svc.Name, filter) {
+				continue
+			}
+			groups[svc.Namespace] = append(groups[svc.Namespace], svc)
+		}
+
+		data := dashboard{
+			Cluster: state.Cluster,
+			Filter:  filter,
+			Refresh: dashboardRefresh,
+			Now:     time.Now(),
+		}
+		for ns, services := range groups {
+			checker.SortServices(services)
+			data.Namespaces = append(data.Namespaces, dashboardNamespace{Name: ns, Services: services})
+		}
+		sort.Slice(data.Namespaces, func(i, j int) bool {
+			return data.Namespaces[i].Name < data.Namespaces[j].Name
+		})
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(w, data); err != nil {
+			http.Error(w, "Error writing response", http.StatusInternalServerError)
+		}
+	}
+}